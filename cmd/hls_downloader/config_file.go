@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"slices"
+)
+
+// processFlags 只作用于整个进程的参数，不能出现在任务配置中
+var processFlags = []string{"config", "log-level"}
+
+// applyConfigFile 把单任务配置文件中的值设置到命令行上没有显式给出的参数。
+// 文件是一个对象，键为参数名（不带 -），值可以是字符串、数字或布尔值；
+// 可重复的参数（如 header）用数组表示，时长写成字符串，例如 {"concurrency": 4, "duration": "2h", "header": ["Referer: https://example.com/"]}
+func applyConfigFile(fs *flag.FlagSet, data []byte) error {
+	raw, err := decodeObject(data)
+	if err != nil {
+		return err
+	}
+	values, err := flagValues(raw)
+	if err != nil {
+		return err
+	}
+	return applyFlagValues(fs, values, explicitFlags(fs))
+}
+
+// decodeObject 解析 JSON 对象，数字保留原文，避免大整数变成浮点数
+func decodeObject(data []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var raw map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("JSON 格式错误: %w", err)
+	}
+	return raw, nil
+}
+
+// flagValues 把 JSON 对象转换为参数名到字符串值的映射
+func flagValues(raw map[string]any) (map[string][]string, error) {
+	values := make(map[string][]string, len(raw))
+	for name, value := range raw {
+		items, err := flagStrings(value)
+		if err != nil {
+			return nil, fmt.Errorf("配置项 %q: %w", name, err)
+		}
+		values[name] = items
+	}
+	return values, nil
+}
+
+// flagStrings 把 JSON 值转换为参数字符串，数组中的每一项各设置一次
+func flagStrings(value any) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case json.Number, bool:
+		return []string{fmt.Sprint(v)}, nil
+	case []any:
+		var items []string
+		for _, item := range v {
+			strs, err := flagStrings(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, strs...)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("不支持的值类型 %T", value)
+	}
+}
+
+// explicitFlags 返回已经显式设置过的参数名
+func explicitFlags(fs *flag.FlagSet) map[string]bool {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	return explicit
+}
+
+// applyFlagValues 设置参数值，跳过 skip 中的参数；未知的参数名视为错误
+func applyFlagValues(fs *flag.FlagSet, values map[string][]string, skip map[string]bool) error {
+	// 按名称排序，错误信息稳定
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if name == "config" {
+			return fmt.Errorf("配置文件中不能再指定 config")
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("未知的配置项 %q，配置项名称与命令行参数相同（不带 -）", name)
+		}
+		if skip[name] {
+			continue
+		}
+		for _, value := range values[name] {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("配置项 %q: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/MGter/hls_downloader/internal/downloader"
+	"github.com/MGter/hls_downloader/internal/storage"
+	"github.com/MGter/hls_downloader/pkg/logger"
+)
+
+// jobFile 多任务配置文件：defaults 中的参数作用于所有任务，每个任务可以覆盖。
+// 任务中的键与命令行参数相同（不带 -），另外支持 name、schedule 和 retention，例如
+//
+//	{
+//	  "defaults": {"concurrency": 4, "header": ["Referer: https://example.com/"]},
+//	  "jobs": [
+//	    {"name": "news", "url": "https://example.com/news.m3u8", "variant": "lowest",
+//	     "schedule": {"daily": "19:00-19:45"}, "retention": "72h"}
+//	  ]
+//	}
+type jobFile struct {
+	Defaults map[string]any   `json:"defaults"`
+	Jobs     []map[string]any `json:"jobs"`
+}
+
+// isJobFile 判断配置文件是否是多任务格式（顶层有 jobs）
+func isJobFile(data []byte) bool {
+	var probe struct {
+		Jobs json.RawMessage `json:"jobs"`
+	}
+	return json.Unmarshal(data, &probe) == nil && probe.Jobs != nil
+}
+
+// jobSpec 一个录制任务的完整配置
+type jobSpec struct {
+	name        string            // 任务名，用于日志和默认保存目录
+	url         string            // M3U8 地址
+	config      downloader.Config // 该任务独立的下载器配置，包括事件通知，任务退出时关闭
+	schedule    schedule          // 录制时间
+	retention   time.Duration     // 片段保留时间，0 表示不删除
+	fingerprint string            // 配置原文，重新加载时用于判断任务是否变化
+}
+
+// loadJobs 读取多任务配置文件。每个任务的参数依次来自：默认值、defaults、任务本身；
+// 命令行上显式给出的参数（args）优先于配置文件，与单任务模式一致。
+// current 是正在运行的任务，配置原文没有变化的任务直接沿用，不重新创建事件通知、加载 cookie；
+// 返回错误时新建的配置都已关闭
+func loadJobs(data []byte, args []string, current map[string]jobSpec) ([]jobSpec, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+
+	var file jobFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("JSON 格式错误: %w", err)
+	}
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("配置文件中没有任务")
+	}
+
+	defaultValues, err := flagValues(file.Defaults)
+	if err != nil {
+		return nil, fmt.Errorf("defaults: %w", err)
+	}
+
+	var specs, built []jobSpec
+	var errs []error
+	names := make(map[string]bool)
+	outputs := make(map[string]string)
+	for i, raw := range file.Jobs {
+		fingerprint, _ := json.Marshal([]any{file.Defaults, raw, args})
+		name, _ := raw["name"].(string)
+		spec, ok := current[name]
+		if !ok || spec.fingerprint != string(fingerprint) {
+			if spec, err = buildJob(raw, defaultValues, args); err != nil {
+				errs = append(errs, fmt.Errorf("第 %d 个任务 %v: %w", i+1, raw["name"], err))
+				continue
+			}
+			spec.fingerprint = string(fingerprint)
+			built = append(built, spec)
+		}
+
+		// 每个任务的状态文件保存在各自的目录中，名称和目录都不能重复
+		if names[spec.name] {
+			errs = append(errs, fmt.Errorf("任务名 %q 重复", spec.name))
+			continue
+		}
+		names[spec.name] = true
+		dir, _ := filepath.Abs(spec.config.OutputDir)
+		if other, ok := outputs[dir]; ok {
+			errs = append(errs, fmt.Errorf("任务 %q 和 %q 使用了同一个保存目录 %s", other, spec.name, spec.config.OutputDir))
+			continue
+		}
+		outputs[dir] = spec.name
+		specs = append(specs, spec)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		for _, spec := range built {
+			closeObserver(spec.config)
+		}
+		return nil, err
+	}
+	return specs, nil
+}
+
+// buildJob 根据一个任务的配置生成任务参数
+func buildJob(raw map[string]any, defaultValues map[string][]string, args []string) (jobSpec, error) {
+	var spec jobSpec
+	values := make(map[string]any, len(raw))
+	for key, value := range raw {
+		values[key] = value
+	}
+
+	// 取出不属于命令行参数的配置项
+	name, _ := values["name"].(string)
+	if name == "" {
+		return spec, fmt.Errorf("缺少任务名 name")
+	}
+	spec.name = name
+	delete(values, "name")
+
+	if value, ok := values["schedule"]; ok {
+		if err := decodeInto(value, &spec.schedule); err != nil {
+			return spec, fmt.Errorf("schedule: %w", err)
+		}
+		if err := spec.schedule.parse(); err != nil {
+			return spec, fmt.Errorf("schedule: %w", err)
+		}
+		delete(values, "schedule")
+	}
+	if value, ok := values["retention"]; ok {
+		text, _ := value.(string)
+		retention, err := time.ParseDuration(text)
+		if err != nil || retention <= 0 {
+			return spec, fmt.Errorf("retention 应为正的时长，例如 \"72h\"")
+		}
+		spec.retention = retention
+		delete(values, "retention")
+	}
+	for _, key := range processFlags {
+		if _, ok := values[key]; ok {
+			return spec, fmt.Errorf("%s 只能在命令行上指定", key)
+		}
+	}
+
+	jobValues, err := flagValues(values)
+	if err != nil {
+		return spec, err
+	}
+
+	// 每个任务使用独立的参数集合：先解析命令行，再依次应用 defaults 和任务配置
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o := newOptions(fs)
+	for _, key := range processFlags {
+		fs.String(key, "", "")
+	}
+	if err := fs.Parse(args); err != nil {
+		return spec, err
+	}
+	explicit := explicitFlags(fs)
+	if err := applyFlagValues(fs, defaultValues, explicit); err != nil {
+		return spec, fmt.Errorf("defaults: %w", err)
+	}
+	if err := applyFlagValues(fs, jobValues, explicit); err != nil {
+		return spec, err
+	}
+
+	if spec.url = *o.streamURL; spec.url == "" {
+		return spec, fmt.Errorf("缺少 url")
+	}
+	if spec.config, err = o.buildConfig(); err != nil {
+		return spec, err
+	}
+	// 没有指定保存目录时以任务名作为目录名
+	if spec.config.OutputDir == "" {
+		spec.config.OutputDir = storage.SanitizeName(name)
+	}
+	return spec, nil
+}
+
+// decodeInto 把已经解析的 JSON 值解码到结构体中
+func decodeInto(value any, target any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// run 按计划运行任务，直到计划结束、一次性任务完成或 ctx 被取消
+func (j *jobSpec) run(ctx context.Context) {
+	if j.retention > 0 {
+		go j.pruneLoop(ctx)
+	}
+
+	for ctx.Err() == nil {
+		begin, end, ok := j.schedule.next(time.Now())
+		if !ok {
+			logger.Info.Printf("[%s] 录制计划已结束\n", j.name)
+			return
+		}
+		if wait := time.Until(begin); wait > 0 {
+			logger.Info.Printf("[%s] 将于 %s 开始录制\n", j.name, begin.Format(time.DateTime))
+			if !sleepUntil(ctx, wait) {
+				return
+			}
+		}
+
+		runCtx, cancel := ctx, context.CancelFunc(func() {})
+		if !end.IsZero() {
+			runCtx, cancel = context.WithDeadline(ctx, end)
+		}
+		logger.Info.Printf("[%s] 开始录制: %s -> %s\n", j.name, j.url, j.config.OutputDir)
+		_, err := downloader.NewWithConfig(j.config).Start(runCtx, j.url)
+		cancel()
+		if err != nil {
+			logger.Error.Printf("[%s] 录制出错: %v\n", j.name, err)
+		} else {
+			logger.Info.Printf("[%s] 本次录制结束\n", j.name)
+		}
+
+		// 没有每日时间段的任务只录制一次
+		if j.schedule.Daily == "" {
+			return
+		}
+		// 时间段内流提前结束或出错时，稍后在同一时间段内重新开始
+		if time.Now().Before(end) && !sleepUntil(ctx, j.config.DownloadInterval) {
+			return
+		}
+	}
+}
+
+// pruneLoop 定期删除超过保留时间的片段
+func (j *jobSpec) pruneLoop(ctx context.Context) {
+	period := min(max(j.retention/10, time.Minute), 10*time.Minute)
+	for {
+		removed, freed, err := storage.PruneOlderThan(j.config.OutputDir, time.Now().Add(-j.retention))
+		switch {
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			logger.Error.Printf("[%s] 清理过期片段失败: %v\n", j.name, err)
+		case removed > 0:
+			logger.Info.Printf("[%s] 已删除 %d 个超过 %v 的片段，释放 %d 字节\n", j.name, removed, j.retention, freed)
+		}
+		if !sleepUntil(ctx, period) {
+			return
+		}
+	}
+}
+
+// sleepUntil 等待指定时间，ctx 取消时返回 false
+func sleepUntil(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// runningJob 正在运行的任务
+type runningJob struct {
+	spec   jobSpec
+	cancel context.CancelFunc
+	done   chan struct{} // 任务退出后关闭
+}
+
+// jobManager 管理所有任务；重新加载配置时只启停新增、删除或变化的任务。
+// running 只在主循环中读写，已退出的任务由 reap 移除，之后重新加载时可以再次启动
+type jobManager struct {
+	ctx      context.Context
+	running  map[string]*runningJob
+	wg       sync.WaitGroup // 包括已删除但还没退出的任务
+	finished chan struct{}  // 有任务退出时通知主循环
+}
+
+// newJobManager 创建任务管理器，ctx 取消时停止所有任务
+func newJobManager(ctx context.Context) *jobManager {
+	return &jobManager{
+		ctx:      ctx,
+		running:  make(map[string]*runningJob),
+		finished: make(chan struct{}, 1),
+	}
+}
+
+// apply 让运行中的任务与 specs 一致：删除的任务停止，新增的任务启动，配置变化的任务等旧实例退出后重启
+func (m *jobManager) apply(specs []jobSpec) {
+	wanted := make(map[string]jobSpec, len(specs))
+	for _, spec := range specs {
+		wanted[spec.name] = spec
+	}
+
+	for name, job := range m.running {
+		if spec, ok := wanted[name]; !ok {
+			logger.Info.Printf("[%s] 任务已从配置中删除，停止录制\n", name)
+			job.cancel()
+			delete(m.running, name)
+		} else if spec.fingerprint != job.spec.fingerprint {
+			logger.Info.Printf("[%s] 任务配置已变化，重新开始录制\n", name)
+			job.cancel()
+			m.start(spec, job.done)
+		}
+	}
+	for name, spec := range wanted {
+		if _, ok := m.running[name]; !ok {
+			m.start(spec, nil)
+		}
+	}
+}
+
+// start 启动任务；after 不为 nil 时先等待旧实例退出，避免两个实例同时写一个目录
+func (m *jobManager) start(spec jobSpec, after <-chan struct{}) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	job := &runningJob{spec: spec, cancel: cancel, done: make(chan struct{})}
+	m.running[spec.name] = job
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer func() {
+			closeObserver(spec.config) // 等待旧实例时被取消的任务也要关闭
+			close(job.done)
+			select {
+			case m.finished <- struct{}{}:
+			default: // 主循环还没处理上一次通知，合并即可
+			}
+		}()
+		if after != nil {
+			select {
+			case <-after:
+			case <-ctx.Done():
+				return
+			}
+		}
+		spec.run(ctx)
+	}()
+}
+
+// specs 返回运行中任务的配置，重新加载时用于判断任务是否变化
+func (m *jobManager) specs() map[string]jobSpec {
+	specs := make(map[string]jobSpec, len(m.running))
+	for name, job := range m.running {
+		specs[name] = job.spec
+	}
+	return specs
+}
+
+// reap 移除已经退出的任务，返回还在运行的任务数
+func (m *jobManager) reap() int {
+	for name, job := range m.running {
+		select {
+		case <-job.done:
+			delete(m.running, name)
+		default:
+		}
+	}
+	return len(m.running)
+}
+
+// runJobs 多任务模式：并发运行配置文件中的所有任务，收到 SIGHUP 时重新加载配置，
+// 收到 SIGINT/SIGTERM 时停止所有任务并返回。所有任务都结束后仍然等待，之后的 SIGHUP 可以加入新任务
+func runJobs(configPath string, data []byte, args []string) error {
+	specs, err := loadJobs(data, args, nil)
+	if err != nil {
+		return err
+	}
+
+	ctx := signalContext()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	manager := newJobManager(ctx)
+	manager.apply(specs)
+	logger.Info.Printf("已启动 %d 个录制任务，发送 SIGHUP 可重新加载 %s\n", len(specs), configPath)
+
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-hup:
+			manager.reap()
+			specs, err := reloadJobs(configPath, args, manager.specs())
+			if err != nil {
+				logger.Error.Printf("重新加载配置失败，继续运行现有任务: %v\n", err)
+				continue
+			}
+			manager.apply(specs)
+			logger.Info.Printf("配置已重新加载，当前 %d 个任务\n", len(specs))
+		case <-manager.finished:
+			if manager.reap() == 0 {
+				logger.Info.Printf("所有录制任务都已结束，发送 SIGHUP 可重新加载 %s，SIGINT/SIGTERM 退出\n", configPath)
+			}
+		}
+	}
+
+	// 等待所有任务（包括已删除、仍在收尾的任务）退出
+	manager.wg.Wait()
+	return nil
+}
+
+// reloadJobs 重新读取配置文件，current 中配置没有变化的任务沿用原来的配置
+func reloadJobs(configPath string, args []string, current map[string]jobSpec) ([]jobSpec, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if !isJobFile(data) {
+		return nil, fmt.Errorf("%s 不再是多任务配置文件", configPath)
+	}
+	return loadJobs(data, args, current)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func specsByName(specs []jobSpec) map[string]jobSpec {
+	byName := make(map[string]jobSpec, len(specs))
+	for _, spec := range specs {
+		byName[spec.name] = spec
+	}
+	return byName
+}
+
+// 重新加载时配置没有变化的任务沿用原来的配置（同一个事件通知），只有变化和新增的任务重新生成
+func TestLoadJobsReusesUnchangedJobs(t *testing.T) {
+	dir := t.TempDir()
+	first := `{"defaults": {"on-event": "true"}, "jobs": [
+		{"name": "a", "url": "https://example.com/a.m3u8", "output": "` + dir + `/a"},
+		{"name": "b", "url": "https://example.com/b.m3u8", "output": "` + dir + `/b"}
+	]}`
+	specs, err := loadJobs([]byte(first), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := specsByName(specs)
+
+	second := `{"defaults": {"on-event": "true"}, "jobs": [
+		{"name": "a", "url": "https://example.com/a.m3u8", "output": "` + dir + `/a"},
+		{"name": "b", "url": "https://example.com/b2.m3u8", "output": "` + dir + `/b"},
+		{"name": "c", "url": "https://example.com/c.m3u8", "output": "` + dir + `/c"}
+	]}`
+	specs, err = loadJobs([]byte(second), nil, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := specsByName(specs)
+
+	if reloaded["a"].config.Observer != current["a"].config.Observer {
+		t.Error("unchanged job a got a new observer")
+	}
+	if reloaded["b"].config.Observer == current["b"].config.Observer || reloaded["b"].fingerprint == current["b"].fingerprint {
+		t.Error("changed job b was not rebuilt")
+	}
+	if reloaded["c"].config.Observer == nil {
+		t.Error("new job c has no observer")
+	}
+	for _, spec := range specs {
+		closeObserver(spec.config)
+	}
+}
+
+// 重新加载失败时不返回任何任务，运行中的任务保持不变
+func TestLoadJobsRejectsInvalidReload(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"jobs": [
+		{"name": "a", "url": "https://example.com/a.m3u8", "output": "` + dir + `/a", "on-event": "true"},
+		{"name": "b", "url": "https://example.com/b.m3u8", "output": "` + dir + `/a"}
+	]}`
+	specs, err := loadJobs([]byte(data), nil, nil)
+	if err == nil {
+		t.Fatal("jobs sharing an output directory were accepted")
+	}
+	if specs != nil {
+		t.Errorf("got %d specs alongside the error, want none", len(specs))
+	}
+}
+
+// 夏令时切换当天，每日时间段仍然是本地时间的 HH:MM，而不是零点加上固定时长
+func TestScheduleDailyAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name       string
+		daily      string
+		now        time.Time
+		begin, end time.Time
+	}{
+		// 2026-03-08 02:00 夏令时开始，当天只有23小时
+		{name: "spring forward", daily: "20:00-22:30", now: at(time.March, 8, 12, 0), begin: at(time.March, 8, 20, 0), end: at(time.March, 8, 22, 30)},
+		{name: "spring forward overnight", daily: "22:00-06:00", now: at(time.March, 7, 12, 0), begin: at(time.March, 7, 22, 0), end: at(time.March, 8, 6, 0)},
+		// 2026-11-01 02:00 夏令时结束，当天有25小时
+		{name: "fall back", daily: "20:00-22:30", now: at(time.November, 1, 12, 0), begin: at(time.November, 1, 20, 0), end: at(time.November, 1, 22, 30)},
+		{name: "fall back in progress", daily: "06:00-08:00", now: at(time.November, 1, 7, 0), begin: at(time.November, 1, 7, 0), end: at(time.November, 1, 8, 0)},
+		{name: "fall back before window", daily: "06:00-08:00", now: at(time.November, 1, 5, 30), begin: at(time.November, 1, 6, 0), end: at(time.November, 1, 8, 0)},
+	}
+	for _, tt := range tests {
+		s := schedule{Daily: tt.daily}
+		if err := s.parse(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		begin, end, ok := s.next(tt.now)
+		if !ok || !begin.Equal(tt.begin) || !end.Equal(tt.end) {
+			t.Errorf("%s: next(%v) = %v - %v (ok %v), want %v - %v", tt.name, tt.now, begin, end, ok, tt.begin, tt.end)
+		}
+	}
+}
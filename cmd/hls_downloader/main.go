@@ -1,43 +1,136 @@
 package main  // 声明这是 main 包，表示这是一个可执行程序
 
 import (
-	"log"      // 标准日志包，用于输出错误信息
+	"context"  // 上下文，用于停止下载器
+	"flag"     // 命令行参数解析
 	"os"       // 操作系统功能包，可以获取命令行参数等
+	"os/signal" // 捕获退出信号
 	"path"     // 路径处理包，这里用来获取程序名
+	"strings"  // 字符串处理
+	"syscall"  // 信号定义
 
 	"github.com/MGter/hls_downloader/internal/downloader"  // 自己写的下载器核心代码
 	"github.com/MGter/hls_downloader/pkg/logger"           // 自己写的日志工具
 )
 
+// opts 命令行上的录制参数；多任务模式下作为每个任务的默认值
+var opts = newOptions(flag.CommandLine)
+
+// 只作用于整个进程的参数
+var (
+	logLevel   = flag.String("log-level", "info", "日志级别: debug / info / warn / error")
+	configFile = flag.String("config", "", "JSON 配置文件：键为参数名（不带 -）的单任务配置，或包含 jobs 的多任务配置；命令行上显式给出的参数优先")
+)
+
 // printHelp 显示帮助信息
 func printHelp() {
 	// path.Base() 获取程序名
 	app := path.Base(os.Args[0])
-	
+
 	// 使用自定义的日志工具输出信息
 	logger.Info.Printf("HLS 直播流下载器\n\n")
-	logger.Info.Printf("用法: %s <M3U8_URL>\n\n", app)  // %s 会被 app 替换
-	logger.Info.Printf("示例: %s https://example.com/live/stream/playlist.m3u8\n", app)
+	logger.Info.Printf("用法: %s [选项] <M3U8_URL>\n\n", app)  // %s 会被 app 替换
+	logger.Info.Printf("示例: %s -variant resolution -resolution 1280x720 https://example.com/live/stream/playlist.m3u8\n", app)
+	logger.Info.Printf("示例: %s -config job.json -duration 1h\n", app)
+	logger.Info.Printf("多任务: %s -config jobs.json（收到 SIGHUP 时重新加载）\n\n", app)
+	flag.PrintDefaults()  // 打印所有选项及默认值
+}
+
+// signalContext 返回收到 SIGINT/SIGTERM 时取消的上下文；再次收到信号时强制退出
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		logger.Info.Printf("收到信号 %v，正在停止录制（再次发送信号将强制退出）\n", sig)
+		cancel()  // 通知下载器停止
+
+		sig = <-sigs
+		logger.Error.Printf("再次收到信号 %v，强制退出\n", sig)
+		os.Exit(1)
+	}()
+
+	return ctx
+}
+
+// fatalf 输出错误并以非零状态退出；不使用 log.Fatalf，避免错误被 -log-level 屏蔽
+func fatalf(format string, args ...any) {
+	logger.Error.Printf(format, args...)
+	os.Exit(1)
 }
 
 // main 函数是程序的入口点，程序从这里开始执行
 func main() {
-	// 检查命令行参数数量，os.Args[0] 是程序名，os.Args[1] 才是第一个参数
-	if len(os.Args) < 2 {
+	flag.Usage = printHelp
+	flag.Parse()
+
+	level, err := logger.ParseLevel(*logLevel)
+	if err != nil {
+		fatalf("参数错误: %v", err)
+	}
+	logger.SetLevel(level)
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			fatalf("读取配置文件失败: %v", err)
+		}
+
+		// 多任务配置：每个任务独立运行，命令行参数作为所有任务的默认值
+		if isJobFile(data) {
+			if flag.NArg() > 0 || *opts.streamURL != "" {
+				fatalf("参数错误: 多任务模式下 M3U8 地址写在配置文件的各个任务中")
+			}
+			if err := runJobs(*configFile, data, os.Args[1:]); err != nil {
+				fatalf("多任务配置错误:\n%v", err)
+			}
+			return
+		}
+
+		// 单任务配置中的值只作用于命令行上没有显式给出的参数
+		if err := applyConfigFile(flag.CommandLine, data); err != nil {
+			fatalf("读取配置文件失败: %s: %v", *configFile, err)
+		}
+		// 配置文件可能修改了日志级别
+		if level, err = logger.ParseLevel(*logLevel); err != nil {
+			fatalf("参数错误: %v", err)
+		}
+		logger.SetLevel(level)
+	}
+
+	// M3U8 地址可以是第一个非选项参数，也可以通过 -url 或配置文件给出
+	hlsURL := *opts.streamURL
+	switch {
+	case flag.NArg() > 1:
+		fatalf("参数错误: 只能指定一个 M3U8 地址，多余的参数: %s（选项需要写在地址之前）", strings.Join(flag.Args()[1:], " "))
+	case flag.NArg() == 1:
+		hlsURL = flag.Arg(0)
+	}
+	if hlsURL == "" {
 		// 如果用户没有输入 M3U8 地址，显示帮助信息
 		printHelp()
 		os.Exit(1)  // 退出程序，1 表示异常退出
 	}
 
-	// 获取用户输入的 M3U8 直播流地址
-	hlsURL := os.Args[1]
-	
+	// 根据命令行参数生成配置，所有错误一次性列出
+	config, err := opts.buildConfig()
+	if err != nil {
+		fatalf("参数错误:\n%v\n使用 -h 查看所有参数", err)
+	}
+
 	// 创建下载器实例
-	dl := downloader.New()
-	
-	// 开始下载直播流
-	if err := dl.Start(hlsURL); err != nil {
+	dl := downloader.NewWithConfig(config)
+
+	// 开始下载直播流，直到流结束或收到退出信号
+	_, err = dl.Start(signalContext(), hlsURL)
+	closeObserver(config)
+	if err != nil {
 		// 如果下载出错，输出错误信息并退出程序
-		log.Fatalf("下载器意外退出: %v", err)  // %v 会显示错误详情
+		fatalf("下载器意外退出: %v", err)  // %v 会显示错误详情
 	}
-}
\ No newline at end of file
+
+	// 流结束或收到退出信号后正常退出
+	logger.Info.Printf("下载器已退出: %s\n", hlsURL)
+}
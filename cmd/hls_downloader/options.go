@@ -0,0 +1,396 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MGter/hls_downloader/internal/downloader"
+	"github.com/MGter/hls_downloader/pkg/utils"
+)
+
+// defaults 下载器的默认配置，命令行参数的默认值取自这里
+var defaults = downloader.DefaultConfig()
+
+// webhookDefaults Webhook 的默认设置
+var webhookDefaults = downloader.DefaultWebhookOptions()
+
+// options 一个录制任务的参数。命令行和配置文件中的每个任务都使用同一组参数名
+type options struct {
+	// 基本的录制参数
+	streamURL   *string
+	outputDir   *string
+	concurrency *int
+	retries     *int
+	retryDelay  *time.Duration
+	retryMax    *time.Duration
+	interval    *time.Duration
+	duration    *time.Duration
+
+	// HTTP超时
+	connectTimeout *time.Duration
+	headerTimeout  *time.Duration
+	requestTimeout *time.Duration
+	minThroughput  *int64
+
+	// 码率版本选择
+	variantPolicy *string
+	resolution    *string
+	maxBandwidth  *int
+	codecs        *string
+	variantIndex  *int
+
+	// 备用音频/字幕渲染
+	withAudio      *bool
+	withSubtitles  *bool
+	languages      *string
+	renditionNames *string
+	defaultOnly    *bool
+
+	// 播放列表刷新
+	minReload *time.Duration
+	maxReload *time.Duration
+
+	// 片段保存
+	keepEncrypted *bool
+	prefixInit    *bool
+	segmentID     *string
+	shutdownGrace *time.Duration
+
+	// 请求头、cookie 和代理，-header 类参数可以重复出现
+	headers         headerFlag
+	playlistHeaders headerFlag
+	keyHeaders      headerFlag
+	segmentHeaders  headerFlag
+	userAgent       *string
+	referer         *string
+	cookieFile      *string
+	proxyAddr       *string
+	proxyScope      *string
+
+	// 事件命令和 Webhook
+	onEvent          *string
+	onEventTypes     *string
+	onEventTimeout   *time.Duration
+	webhookURL       *string
+	webhookSecret    *string
+	webhookEvents    *string
+	webhookTimeout   *time.Duration
+	webhookRetries   *int
+	failureThreshold *int
+	diskQuota        *string
+}
+
+// newOptions 在 fs 上注册录制任务的全部参数
+func newOptions(fs *flag.FlagSet) *options {
+	o := &options{
+		streamURL:   fs.String("url", "", "M3U8 地址，也可以作为第一个非选项参数给出"),
+		outputDir:   fs.String("output", "", "保存目录，默认根据 URL 生成"),
+		concurrency: fs.Int("concurrency", defaults.MaxConcurrentDownloads, "同时下载的片段数"),
+		retries:     fs.Int("retries", defaults.MaxRetryAttempts, "每个请求最多尝试的次数（包括第一次）"),
+		retryDelay:  fs.Duration("retry-delay", defaults.RetryDelayBase, "第一次重试前的退避上限，之后每次翻倍并加随机抖动"),
+		retryMax:    fs.Duration("retry-max-delay", defaults.RetryMaxDelay, "两次重试之间的最长等待时间"),
+		interval:    fs.Duration("interval", defaults.DownloadInterval, "播放列表没有目标时长或出错时的检查间隔"),
+		duration:    fs.Duration("duration", 0, "录制时长上限，例如 2h30m，0 表示一直录制到流结束或收到退出信号"),
+
+		connectTimeout: fs.Duration("connect-timeout", defaults.HTTP.ConnectTimeout, "建立连接（含 TLS 握手）的超时"),
+		headerTimeout:  fs.Duration("header-timeout", defaults.HTTP.ResponseHeaderTimeout, "等待响应头的超时"),
+		requestTimeout: fs.Duration("timeout", defaults.HTTP.TransferTimeout, "单个请求从发出到读完响应体的总超时，0 表示不限制"),
+		minThroughput:  fs.Int64("min-throughput", defaults.HTTP.MinThroughput, "读取响应体的最低速度（字节/秒），持续低于该速度时中断重试，0 表示不检测"),
+
+		variantPolicy: fs.String("variant", string(defaults.VariantSelection.Policy), "码率版本选择策略: highest / lowest / resolution / max-bitrate / index / first"),
+		resolution:    fs.String("resolution", "", "resolution 策略的目标分辨率，例如 1920x1080"),
+		maxBandwidth:  fs.Int("max-bandwidth", 0, "码率上限（bit/s），0 表示不限制"),
+		codecs:        fs.String("codec", "", "编码偏好，逗号分隔并按优先级排列，例如 avc1,hevc"),
+		variantIndex:  fs.Int("variant-index", 0, "index 策略使用的版本序号（从0开始）"),
+
+		withAudio:      fs.Bool("audio", true, "录制所选版本引用的备用音轨"),
+		withSubtitles:  fs.Bool("subtitles", true, "录制所选版本引用的字幕"),
+		languages:      fs.String("lang", "", "只录制这些语言的音轨/字幕，逗号分隔，例如 en,zh"),
+		renditionNames: fs.String("rendition-name", "", "只录制这些名称的音轨/字幕，逗号分隔"),
+		defaultOnly:    fs.Bool("default-only", false, "只录制 DEFAULT=YES 的音轨/字幕"),
+
+		minReload: fs.Duration("min-reload", defaults.MinReloadInterval, "按目标时长自适应刷新时的最小间隔"),
+		maxReload: fs.Duration("max-reload", defaults.MaxReloadInterval, "按目标时长自适应刷新时的最大间隔"),
+
+		keepEncrypted: fs.Bool("keep-encrypted", false, "解密的同时保留加密原文件（.enc）和密钥（keys/），用于归档"),
+		prefixInit:    fs.Bool("prefix-init", false, "在每个 fMP4 分片前拼接初始化片段，生成可独立播放的文件"),
+		segmentID:     fs.String("segment-id", string(defaults.SegmentIdentity), "片段去重的标识策略: sequence（媒体序列号）/ filename（文件名末尾的数字）"),
+		shutdownGrace: fs.Duration("shutdown-grace", defaults.ShutdownGracePeriod, "收到 SIGINT/SIGTERM 后等待进行中的片段下载完成的最长时间，0 表示立即中断"),
+
+		headers:         headerFlag{},
+		playlistHeaders: headerFlag{},
+		keyHeaders:      headerFlag{},
+		segmentHeaders:  headerFlag{},
+		userAgent:       fs.String("user-agent", "", "所有请求使用的 User-Agent"),
+		referer:         fs.String("referer", "", "所有请求使用的 Referer"),
+		cookieFile:      fs.String("cookies", "", "Netscape 格式的 cookie 文件（curl/浏览器导出）"),
+		proxyAddr:       fs.String("proxy", "", "代理地址，支持 http://、https://（CONNECT）和 socks5://，认证信息写成 user:pass@host:port"),
+		proxyScope:      fs.String("proxy-scope", "all", "走代理的请求: all / playlists（播放列表和密钥）/ segments"),
+
+		onEvent:        fs.String("on-event", "", "每个录制事件执行的命令（sh -c），事件信息在 HLS_EVENT、HLS_SEGMENT_FILE 等环境变量中"),
+		onEventTypes:   fs.String("on-event-types", "", "只对这些事件执行命令，逗号分隔，例如 segment_completed,stream_ended；默认所有事件"),
+		onEventTimeout: fs.Duration("on-event-timeout", time.Minute, "单个事件命令的最长执行时间，0 表示不限制"),
+
+		webhookURL:       fs.String("webhook", "", "把事件以 JSON POST 到这个地址，后台发送并按指数退避重试"),
+		webhookSecret:    fs.String("webhook-secret", "", "Webhook 签名密钥，请求头 X-HLS-Signature 为 sha256=<HMAC-SHA256(X-HLS-Timestamp + \".\" + 请求体)>"),
+		webhookEvents:    fs.String("webhook-events", strings.Join(downloader.DefaultWebhookEvents, ","), "发送到 Webhook 的事件，逗号分隔"),
+		webhookTimeout:   fs.Duration("webhook-timeout", webhookDefaults.Timeout, "单次 Webhook 请求的超时"),
+		webhookRetries:   fs.Int("webhook-retries", webhookDefaults.MaxAttempts, "每个 Webhook 事件最多尝试的次数（包括第一次）"),
+		failureThreshold: fs.Int("failure-threshold", defaults.FailureThreshold, "连续失败多少轮后发送 repeated_failure 事件，0 表示不发送"),
+		diskQuota:        fs.String("disk-quota", "", "保存目录最多占用的空间，例如 500M、20G，超过后发送 disk_quota 事件并停止录制"),
+	}
+	fs.Var(o.headers, "header", "所有请求附加的请求头，格式 \"Name: value\"，可重复")
+	fs.Var(o.playlistHeaders, "playlist-header", "只附加到播放列表请求的请求头，可重复")
+	fs.Var(o.keyHeaders, "key-header", "只附加到密钥请求的请求头，可重复")
+	fs.Var(o.segmentHeaders, "segment-header", "只附加到片段请求的请求头，可重复")
+	return o
+}
+
+// headerFlag 可重复的 "Name: value" 请求头参数
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var items []string
+	for name, values := range h {
+		for _, value := range values {
+			items = append(items, name+": "+value)
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+func (h headerFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("请求头格式应为 \"Name: value\": %s", value)
+	}
+	http.Header(h).Add(name, strings.TrimSpace(val))
+	return nil
+}
+
+// buildConfig 根据参数生成下载器配置，返回所有参数错误而不只是第一个
+func (o *options) buildConfig() (downloader.Config, error) {
+	var errs []error
+	config := downloader.DefaultConfig()
+
+	config.OutputDir = *o.outputDir
+	config.MaxDuration = *o.duration
+	config.MaxConcurrentDownloads = *o.concurrency
+	config.MaxRetryAttempts = *o.retries
+	config.RetryDelayBase = *o.retryDelay
+	config.RetryMaxDelay = *o.retryMax
+	config.DownloadInterval = *o.interval
+	config.MinReloadInterval = *o.minReload
+	config.MaxReloadInterval = *o.maxReload
+	config.ShutdownGracePeriod = *o.shutdownGrace
+	config.KeepEncrypted = *o.keepEncrypted
+	config.PrefixInitSegment = *o.prefixInit
+	config.Renditions = o.buildRenditionFilter()
+
+	selection, err := o.buildVariantSelection()
+	errs = append(errs, err)
+	config.VariantSelection = selection
+	config.SegmentIdentity, err = downloader.ParseSegmentIdentity(*o.segmentID)
+	errs = append(errs, err)
+
+	// HTTP 设置
+	config.HTTP.ConnectTimeout = *o.connectTimeout
+	config.HTTP.TLSHandshakeTimeout = *o.connectTimeout
+	config.HTTP.ResponseHeaderTimeout = *o.headerTimeout
+	config.HTTP.TransferTimeout = *o.requestTimeout
+	config.HTTP.MinThroughput = *o.minThroughput
+	config.HTTP.Headers = o.buildRequestHeaders()
+	config.HTTP.Proxy, err = o.buildProxy()
+	errs = append(errs, err)
+	if *o.cookieFile != "" {
+		config.HTTP.Jar, err = utils.LoadCookieFile(*o.cookieFile)
+		errs = append(errs, err)
+	}
+
+	// 事件通知
+	config.FailureThreshold = *o.failureThreshold
+	config.DiskQuota, err = parseByteSize(*o.diskQuota)
+	errs = append(errs, err)
+	webhook := o.buildWebhookOptions()
+	if webhook != nil {
+		errs = append(errs, webhook.Validate())
+	}
+
+	// 只有参数本身都能解析时才检查取值范围，避免同一个问题报两次
+	if err := errors.Join(errs...); err != nil {
+		return config, err
+	}
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+
+	// 所有参数都检查过之后才创建事件通知，参数错误时没有需要关闭的 Observer
+	config.Observer, err = o.buildObservers(webhook)
+	return config, err
+}
+
+// buildWebhookOptions 根据参数生成 Webhook 设置，没有指定 -webhook 时返回 nil
+func (o *options) buildWebhookOptions() *downloader.WebhookOptions {
+	if *o.webhookURL == "" {
+		return nil
+	}
+	options := webhookDefaults
+	options.URL = *o.webhookURL
+	options.Secret = *o.webhookSecret
+	options.Events = splitList(*o.webhookEvents)
+	options.Timeout = *o.webhookTimeout
+	options.MaxAttempts = *o.webhookRetries
+	return &options
+}
+
+// buildObservers 创建事件命令和 Webhook，出错时关闭已经创建的；
+// 两者都在第一个事件到来时才启动后台协程
+func (o *options) buildObservers(webhook *downloader.WebhookOptions) (downloader.Observer, error) {
+	var observers []downloader.Observer
+	if *o.onEvent != "" {
+		observer, err := downloader.NewCommandObserver(*o.onEvent, splitList(*o.onEventTypes), *o.onEventTimeout)
+		if err != nil {
+			return nil, err
+		}
+		observers = append(observers, observer)
+	}
+	if webhook != nil {
+		observer, err := downloader.NewWebhookObserver(*webhook)
+		if err != nil {
+			for _, created := range observers {
+				created.(io.Closer).Close()
+			}
+			return nil, err
+		}
+		observers = append(observers, observer)
+	}
+	return downloader.MultiObserver(observers...), nil
+}
+
+// buildVariantSelection 根据参数生成码率版本选择配置
+func (o *options) buildVariantSelection() (downloader.VariantSelection, error) {
+	policy, err := downloader.ParseVariantPolicy(*o.variantPolicy)
+	if err != nil {
+		return downloader.VariantSelection{}, err
+	}
+
+	selection := downloader.VariantSelection{
+		Policy:       policy,
+		MaxBandwidth: *o.maxBandwidth,
+		Index:        *o.variantIndex,
+	}
+
+	// 解析 <宽>x<高> 格式的目标分辨率
+	if *o.resolution != "" {
+		w, h, ok := strings.Cut(strings.ToLower(*o.resolution), "x")
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if !ok || errW != nil || errH != nil || width <= 0 || height <= 0 {
+			return downloader.VariantSelection{}, fmt.Errorf("无效的分辨率: %s", *o.resolution)
+		}
+		selection.TargetWidth, selection.TargetHeight = width, height
+	} else if policy == downloader.VariantResolution {
+		return downloader.VariantSelection{}, fmt.Errorf("resolution 策略需要通过 -resolution 指定目标分辨率")
+	}
+
+	// 编码偏好是逗号分隔的列表
+	selection.CodecPreference = splitList(*o.codecs)
+
+	return selection, nil
+}
+
+// buildRenditionFilter 根据参数生成备用渲染的录制范围
+func (o *options) buildRenditionFilter() downloader.RenditionFilter {
+	return downloader.RenditionFilter{
+		Audio:       *o.withAudio,
+		Subtitles:   *o.withSubtitles,
+		Languages:   splitList(*o.languages),
+		Names:       splitList(*o.renditionNames),
+		DefaultOnly: *o.defaultOnly,
+	}
+}
+
+// buildRequestHeaders 根据参数生成自定义请求头，-user-agent / -referer 会覆盖 -header 中的同名项
+func (o *options) buildRequestHeaders() utils.RequestHeaders {
+	common := http.Header(o.headers).Clone()
+	if *o.userAgent != "" {
+		common.Set("User-Agent", *o.userAgent)
+	}
+	if *o.referer != "" {
+		common.Set("Referer", *o.referer)
+	}
+	return utils.RequestHeaders{
+		Common:   common,
+		Playlist: http.Header(o.playlistHeaders),
+		Key:      http.Header(o.keyHeaders),
+		Segment:  http.Header(o.segmentHeaders),
+	}
+}
+
+// buildProxy 根据参数生成代理设置，未指定 -proxy 时沿用环境变量
+func (o *options) buildProxy() (utils.ProxyOptions, error) {
+	scope, err := utils.ParseProxyScope(*o.proxyScope)
+	if err != nil {
+		return utils.ProxyOptions{}, err
+	}
+	if *o.proxyAddr == "" {
+		return utils.ProxyOptions{Scope: scope}, nil
+	}
+	proxyURL, err := utils.ParseProxyURL(*o.proxyAddr)
+	if err != nil {
+		return utils.ProxyOptions{}, err
+	}
+	return utils.ProxyOptions{URL: proxyURL, Scope: scope}, nil
+}
+
+// splitList 拆分逗号分隔的列表，去掉空白项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseByteSize 解析 500M、20G 这样的空间大小（1024 进制），纯数字表示字节，空字符串表示 0
+func parseByteSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}}
+	multiplier := int64(1)
+	number := strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	for _, unit := range units {
+		if trimmed, ok := strings.CutSuffix(number, unit.suffix); ok {
+			number, multiplier = trimmed, unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("无效的空间大小 %q，例如 500M、20G", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// closeObserver 录制结束后等待事件命令执行完、Webhook 发送完
+func closeObserver(config downloader.Config) {
+	if closer, ok := config.Observer.(io.Closer); ok {
+		closer.Close()
+	}
+}
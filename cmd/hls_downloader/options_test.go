@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/MGter/hls_downloader/internal/downloader"
+)
+
+// parseOptions 用独立的参数集合解析命令行参数
+func parseOptions(t *testing.T, args ...string) *options {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o := newOptions(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// 参数有错误时不创建事件通知，不会留下没有关闭的 Observer
+func TestBuildConfigCreatesObserversLast(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string // 错误信息中应包含的内容
+	}{
+		{name: "flag error", args: []string{"-on-event", "true", "-segment-id", "uri"}, want: []string{"uri"}},
+		{name: "range error", args: []string{"-on-event", "true", "-webhook", "https://example.com/hook", "-concurrency", "0"}, want: []string{"并发下载数"}},
+		{
+			name: "webhook checked with other flags",
+			args: []string{"-on-event", "true", "-webhook", "example.com/hook", "-disk-quota", "10X"},
+			want: []string{"example.com/hook", "10X"},
+		},
+		{name: "event command", args: []string{"-on-event", "true", "-on-event-types", "segment_done", "-webhook", "https://example.com/hook"}, want: []string{"segment_done"}},
+	}
+	for _, tt := range tests {
+		config, err := parseOptions(t, tt.args...).buildConfig()
+		if err == nil {
+			closeObserver(config)
+			t.Errorf("%s: buildConfig succeeded, want error", tt.name)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error %q does not mention %q", tt.name, err, want)
+			}
+		}
+		if config.Observer != nil {
+			closeObserver(config)
+			t.Errorf("%s: buildConfig returned an observer with an error", tt.name)
+		}
+	}
+
+	config, err := parseOptions(t, "-on-event", "true", "-webhook", "https://example.com/hook").buildConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Observer == nil {
+		t.Fatal("valid flags: no observer")
+	}
+	closeObserver(config)
+}
+
+// 没有指定的参数使用下载器的默认配置
+func TestBuildConfigDefaults(t *testing.T) {
+	config, err := parseOptions(t).buildConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := downloader.DefaultConfig()
+	if config.VariantSelection.Policy != defaults.VariantSelection.Policy {
+		t.Errorf("variant policy %q, want %q", config.VariantSelection.Policy, defaults.VariantSelection.Policy)
+	}
+	if config.SegmentIdentity != defaults.SegmentIdentity {
+		t.Errorf("segment identity %q, want %q", config.SegmentIdentity, defaults.SegmentIdentity)
+	}
+	if config.MaxConcurrentDownloads != defaults.MaxConcurrentDownloads || config.MaxRetryAttempts != defaults.MaxRetryAttempts ||
+		config.MinReloadInterval != defaults.MinReloadInterval || config.MaxReloadInterval != defaults.MaxReloadInterval {
+		t.Errorf("config %+v differs from defaults", config)
+	}
+}
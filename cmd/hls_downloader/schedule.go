@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// schedule 任务的录制时间。都不设置时立即开始并一直录制；
+// start/stop 限定整体的起止时间，daily 指定每天录制的时间段，两者可以同时使用
+type schedule struct {
+	Start time.Time `json:"start"` // 开始时间（RFC 3339），零值表示立即开始
+	Stop  time.Time `json:"stop"`  // 结束时间（RFC 3339），零值表示不结束
+	Daily string    `json:"daily"` // 每天的录制时间段（本地时间），例如 "20:00-22:30"，跨午夜写成 "23:00-01:00"
+
+	dailyStart, dailyEnd time.Duration // 解析后的时间段，相对当天零点
+}
+
+// parse 校验并解析时间段
+func (s *schedule) parse() error {
+	if !s.Start.IsZero() && !s.Stop.IsZero() && !s.Stop.After(s.Start) {
+		return fmt.Errorf("结束时间 %v 早于开始时间 %v", s.Stop, s.Start)
+	}
+	if s.Daily == "" {
+		return nil
+	}
+
+	from, to, ok := strings.Cut(s.Daily, "-")
+	start, errStart := parseClock(from)
+	end, errEnd := parseClock(to)
+	if !ok || errStart != nil || errEnd != nil || start == end {
+		return fmt.Errorf("无效的每日时间段 %q，格式应为 HH:MM-HH:MM", s.Daily)
+	}
+	s.dailyStart, s.dailyEnd = start, end
+	return nil
+}
+
+// parseClock 解析 HH:MM 格式的时刻
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// next 返回 now 之后（或正在进行中）的下一个录制时段；end 为零值表示不结束，ok 为 false 表示计划已经结束
+func (s *schedule) next(now time.Time) (begin, end time.Time, ok bool) {
+	ref := now
+	if s.Start.After(ref) {
+		ref = s.Start
+	}
+
+	begin, end = ref, time.Time{}
+	if s.Daily != "" {
+		// 跨午夜的时间段可能从前一天开始，依次检查前一天、当天和后一天。
+		// 时刻按 now 所在时区的日历计算，夏令时切换当天也是本地时间的 HH:MM
+		day := ref.In(now.Location())
+		for offset := -1; offset <= 1; offset++ {
+			windowStart := clockTime(day, offset, s.dailyStart)
+			windowEnd := clockTime(day, offset, s.dailyEnd)
+			if s.dailyEnd < s.dailyStart {
+				windowEnd = clockTime(day, offset+1, s.dailyEnd)
+			}
+			if windowEnd.After(ref) {
+				begin, end = windowStart, windowEnd
+				if begin.Before(ref) {
+					begin = ref
+				}
+				break
+			}
+		}
+	}
+
+	if !s.Stop.IsZero() {
+		if !begin.Before(s.Stop) {
+			return time.Time{}, time.Time{}, false
+		}
+		if end.IsZero() || end.After(s.Stop) {
+			end = s.Stop
+		}
+	}
+	return begin, end, true
+}
+
+// clockTime 返回 day 之后第 offset 天 clock 时刻的本地时间
+func clockTime(day time.Time, offset int, clock time.Duration) time.Time {
+	hour, minute := int(clock/time.Hour), int(clock%time.Hour/time.Minute)
+	return time.Date(day.Year(), day.Month(), day.Day()+offset, hour, minute, 0, 0, day.Location())
+}
@@ -0,0 +1,326 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MGter/hls_downloader/pkg/logger"
+)
+
+// 事件名称，用于 CommandObserver 的事件过滤和 HLS_EVENT 环境变量
+const (
+	EventRecordingStarted = "recording_started"
+	EventPlaylistReloaded = "playlist_reloaded"
+	EventVariantSwitched  = "variant_switched"
+	EventSegmentStarted   = "segment_started"
+	EventSegmentCompleted = "segment_completed"
+	EventSegmentFailed    = "segment_failed"
+	EventDiscontinuity    = "discontinuity"
+	EventStreamEnded      = "stream_ended"
+	EventStalled          = "stalled"
+	EventRepeatedFailure  = "repeated_failure"
+	EventDiskQuota        = "disk_quota"
+	EventError            = "error"
+)
+
+// EventNames 所有事件名称
+var EventNames = []string{
+	EventRecordingStarted, EventPlaylistReloaded, EventVariantSwitched, EventSegmentStarted,
+	EventSegmentCompleted, EventSegmentFailed, EventDiscontinuity, EventStreamEnded,
+	EventStalled, EventRepeatedFailure, EventDiskQuota, EventError,
+}
+
+// checkEventNames 检查事件名称，返回用于过滤的集合
+func checkEventNames(events []string) (map[string]bool, error) {
+	filter := make(map[string]bool, len(events))
+	for _, name := range events {
+		if !slices.Contains(EventNames, name) {
+			return nil, fmt.Errorf("未知的事件 %q（可选 %s）", name, strings.Join(EventNames, " / "))
+		}
+		filter[name] = true
+	}
+	return filter, nil
+}
+
+// commandQueueSize 等待执行的事件命令数上限，队列满时丢弃新事件，不让慢命令拖慢录制
+const commandQueueSize = 256
+
+// CommandObserver 对每个事件运行一次外部命令，例如上传或索引刚保存的片段。
+// 命令通过 sh -c（Windows 上为 cmd /C）执行，事件信息放在 HLS_ 开头的环境变量中，
+// 例如 HLS_EVENT、HLS_TRACK、HLS_SEGMENT_FILE。命令在后台按事件发生的顺序逐个执行，不阻塞录制；
+// 录制结束后调用 Close 等待剩余的命令执行完
+type CommandObserver struct {
+	command string          // 要执行的命令
+	events  map[string]bool // 需要执行命令的事件，空表示所有事件
+	timeout time.Duration   // 单个命令的最长执行时间，0 表示不限制
+
+	mu      sync.Mutex      // 保护 started、closed 和 dropped，保证 Close 之后不再入队
+	started bool            // 执行协程是否已经启动，第一个事件到来时才启动
+	closed  bool            // 是否已经关闭
+	dropped int             // 队列已满时丢弃的事件数
+	queue   chan commandJob // 等待执行的命令
+	done    chan struct{}   // 执行协程退出后关闭
+}
+
+// NewCommandObserver 创建对 events 中的事件执行 command 的 Observer，events 为空时对所有事件执行
+func NewCommandObserver(command string, events []string, timeout time.Duration) (*CommandObserver, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("事件命令不能为空")
+	}
+
+	filter, err := checkEventNames(events)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CommandObserver{
+		command: command,
+		events:  filter,
+		timeout: timeout,
+		queue:   make(chan commandJob, commandQueueSize),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Close 停止接收事件，等待已经入队的命令执行完
+func (o *CommandObserver) Close() error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	started, dropped := o.started, o.dropped
+	close(o.queue)
+	o.mu.Unlock()
+
+	if dropped > 0 {
+		logger.Warn.Printf("事件命令队列已满，共丢弃了 %d 个事件", dropped)
+	}
+	if started {
+		<-o.done
+	}
+	return nil
+}
+
+// enqueue 把事件放入执行队列，没有订阅该事件、已经关闭或队列已满时丢弃
+func (o *CommandObserver) enqueue(event string, env commandEnv) {
+	if len(o.events) > 0 && !o.events[event] {
+		return
+	}
+	env.set("EVENT", event)
+	env.set("TIME", time.Now())
+	job := commandJob{event: event, env: env}
+
+	// 持有锁时只做不阻塞的发送，Close 不会等待入队，也不会在发送期间关闭队列
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	if !o.started {
+		o.started = true
+		go o.run()
+	}
+	select {
+	case o.queue <- job:
+	default:
+		// 命令执行得比事件产生得慢时丢弃事件，录制不能等待命令
+		if o.dropped == 0 {
+			logger.Warn.Printf("事件命令队列已满（%d 个），开始丢弃新事件", cap(o.queue))
+		}
+		o.dropped++
+	}
+}
+
+// commandJob 一次事件命令
+type commandJob struct {
+	event string     // 事件名称
+	env   commandEnv // 事件信息
+}
+
+// run 按顺序执行队列中的命令，直到队列关闭
+func (o *CommandObserver) run() {
+	defer close(o.done)
+	for job := range o.queue {
+		o.exec(job)
+	}
+}
+
+// exec 执行一次命令，失败只记录日志
+func (o *CommandObserver) exec(job commandJob) {
+	ctx := context.Background()
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.CommandContext(ctx, shell, flag, o.command)
+	cmd.Env = append(os.Environ(), job.env...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		logger.Warn.Printf("事件 %s 的命令执行失败: %v, 输出: %s", job.event, err, strings.TrimSpace(string(output)))
+	}
+}
+
+// commandEnv 传给事件命令的环境变量
+type commandEnv []string
+
+// set 追加一个环境变量，零值也会设置，命令可以依赖变量总是存在
+func (e *commandEnv) set(name string, value any) {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		// 没有错误时设置为空字符串
+	case bool:
+		s = "0"
+		if v {
+			s = "1"
+		}
+	case time.Duration:
+		s = strconv.FormatFloat(v.Seconds(), 'f', -1, 64)
+	case time.Time:
+		if !v.IsZero() {
+			s = v.Format(time.RFC3339Nano)
+		}
+	case error:
+		s = v.Error()
+	default:
+		s = fmt.Sprint(v)
+	}
+	*e = append(*e, "HLS_"+name+"="+s)
+}
+
+// segmentEnv 片段事件的环境变量
+func segmentEnv(e SegmentEvent) commandEnv {
+	var env commandEnv
+	env.set("TRACK", e.Track)
+	env.set("SEGMENT_URI", e.URI)
+	env.set("SEGMENT_SEQUENCE", e.Sequence)
+	env.set("SEGMENT_DISCONTINUITY_SEQUENCE", e.DiscontinuitySequence)
+	env.set("SEGMENT_DISCONTINUITY", e.Discontinuity)
+	env.set("SEGMENT_DURATION", e.Duration)
+	env.set("SEGMENT_PROGRAM_DATE_TIME", e.ProgramDateTime)
+	env.set("SEGMENT_FILE", e.File)
+	env.set("SEGMENT_SIZE", e.Size)
+	env.set("SEGMENT_CHECKSUM", e.Checksum)
+	env.set("SEGMENT_ATTEMPTS", e.Attempts)
+	env.set("ERROR", e.Err)
+	return env
+}
+
+// RecordingStarted 实现 Observer
+func (o *CommandObserver) RecordingStarted(e RecordingEvent) {
+	var env commandEnv
+	env.set("URL", e.URL)
+	env.set("OUTPUT_DIR", e.OutputDir)
+	env.set("TRACKS", strings.Join(e.Tracks, ","))
+	o.enqueue(EventRecordingStarted, env)
+}
+
+// PlaylistReloaded 实现 Observer
+func (o *CommandObserver) PlaylistReloaded(e PlaylistEvent) {
+	var env commandEnv
+	env.set("TRACK", e.Track)
+	env.set("PLAYLIST_URL", e.URL)
+	env.set("MEDIA_SEQUENCE", e.MediaSequence)
+	env.set("PLAYLIST_SEGMENTS", e.Segments)
+	env.set("TARGET_DURATION", e.TargetDuration)
+	env.set("PLAYLIST_CHANGED", e.Changed)
+	env.set("PLAYLIST_ENDED", e.Ended)
+	o.enqueue(EventPlaylistReloaded, env)
+}
+
+// VariantSwitched 实现 Observer
+func (o *CommandObserver) VariantSwitched(e VariantEvent) {
+	var env commandEnv
+	env.set("TRACK", "main")
+	env.set("MASTER_URL", e.MasterURL)
+	env.set("VARIANT_URI", e.URI)
+	env.set("VARIANT_POLICY", e.Policy)
+	env.set("VARIANT_BANDWIDTH", e.Bandwidth)
+	env.set("VARIANT_RESOLUTION", fmt.Sprintf("%dx%d", e.Width, e.Height))
+	env.set("VARIANT_CODECS", strings.Join(e.Codecs, ","))
+	o.enqueue(EventVariantSwitched, env)
+}
+
+// SegmentStarted 实现 Observer
+func (o *CommandObserver) SegmentStarted(e SegmentEvent) {
+	o.enqueue(EventSegmentStarted, segmentEnv(e))
+}
+
+// SegmentCompleted 实现 Observer
+func (o *CommandObserver) SegmentCompleted(e SegmentEvent) {
+	o.enqueue(EventSegmentCompleted, segmentEnv(e))
+}
+
+// SegmentFailed 实现 Observer
+func (o *CommandObserver) SegmentFailed(e SegmentEvent) {
+	o.enqueue(EventSegmentFailed, segmentEnv(e))
+}
+
+// Discontinuity 实现 Observer
+func (o *CommandObserver) Discontinuity(e SegmentEvent) {
+	o.enqueue(EventDiscontinuity, segmentEnv(e))
+}
+
+// StreamEnded 实现 Observer
+func (o *CommandObserver) StreamEnded(e StreamEvent) {
+	var env commandEnv
+	env.set("TRACK", e.Track)
+	env.set("PLAYLIST_URL", e.URL)
+	env.set("SEGMENTS_QUEUED", e.Queued)
+	env.set("BYTES", e.Bytes)
+	env.set("SEGMENTS_LOST", e.Lost)
+	env.set("ERROR", e.Err)
+	o.enqueue(EventStreamEnded, env)
+}
+
+// Stalled 实现 Observer
+func (o *CommandObserver) Stalled(e StallEvent) {
+	var env commandEnv
+	env.set("TRACK", e.Track)
+	env.set("PLAYLIST_URL", e.URL)
+	env.set("STALLED_FOR", e.Since)
+	env.set("TARGET_DURATION", e.TargetDuration)
+	o.enqueue(EventStalled, env)
+}
+
+// RepeatedFailure 实现 Observer
+func (o *CommandObserver) RepeatedFailure(e FailureEvent) {
+	var env commandEnv
+	env.set("TRACK", e.Track)
+	env.set("PLAYLIST_URL", e.URL)
+	env.set("FAILURES", e.Count)
+	env.set("ERROR", e.Err)
+	o.enqueue(EventRepeatedFailure, env)
+}
+
+// DiskQuotaExceeded 实现 Observer
+func (o *CommandObserver) DiskQuotaExceeded(e QuotaEvent) {
+	var env commandEnv
+	env.set("OUTPUT_DIR", e.OutputDir)
+	env.set("QUOTA", e.Limit)
+	env.set("USED", e.Used)
+	o.enqueue(EventDiskQuota, env)
+}
+
+// Error 实现 Observer
+func (o *CommandObserver) Error(e ErrorEvent) {
+	var env commandEnv
+	env.set("TRACK", e.Track)
+	env.set("ERROR", e.Err)
+	o.enqueue(EventError, env)
+}
@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func skipWithoutShell(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("事件命令测试使用 sh")
+	}
+}
+
+// 命令按事件顺序执行，事件信息在环境变量中；Close 等待所有命令执行完
+func TestCommandObserverRunsCommands(t *testing.T) {
+	skipWithoutShell(t)
+	out := filepath.Join(t.TempDir(), "events.txt")
+	t.Setenv("EVENTS_FILE", out)
+
+	o, err := NewCommandObserver(`echo "$HLS_EVENT $HLS_SEGMENT_SEQUENCE" >> "$EVENTS_FILE"`, []string{EventSegmentCompleted}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := 1; seq <= 3; seq++ {
+		o.SegmentStarted(SegmentEvent{Track: "main", Sequence: seq}) // 没有订阅，不执行
+		o.SegmentCompleted(SegmentEvent{Track: "main", Sequence: seq})
+	}
+	o.Close()
+	o.SegmentCompleted(SegmentEvent{Track: "main", Sequence: 4}) // 关闭后忽略
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "segment_completed 1\nsegment_completed 2\nsegment_completed 3\n"
+	if string(data) != want {
+		t.Errorf("commands wrote %q, want %q", data, want)
+	}
+}
+
+// 命令比事件慢时队列会满，之后的事件被丢弃而不是阻塞录制
+func TestCommandObserverDropsWhenQueueFull(t *testing.T) {
+	skipWithoutShell(t)
+	release := filepath.Join(t.TempDir(), "release")
+	t.Setenv("RELEASE_FILE", release)
+
+	o, err := NewCommandObserver(`while [ ! -f "$RELEASE_FILE" ]; do sleep 0.01; done`, nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const events = commandQueueSize + 100
+	start := time.Now()
+	for seq := 0; seq < events; seq++ {
+		o.SegmentCompleted(SegmentEvent{Track: "main", Sequence: seq})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("enqueueing %d events took %v, recording would stall", events, elapsed)
+	}
+
+	o.mu.Lock()
+	dropped := o.dropped
+	o.mu.Unlock()
+	// 执行协程最多取走一个事件，其余的超出队列长度的都被丢弃
+	if dropped < events-commandQueueSize-1 {
+		t.Errorf("dropped %d events, want at least %d", dropped, events-commandQueueSize-1)
+	}
+
+	if err := os.WriteFile(release, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		o.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Close did not return after the commands finished")
+	}
+}
+
+func TestNewCommandObserverRejectsUnknownEvent(t *testing.T) {
+	_, err := NewCommandObserver("true", []string{"segment_done"}, 0)
+	if err == nil || !strings.Contains(err.Error(), "segment_done") {
+		t.Errorf("unknown event: err = %v", err)
+	}
+}
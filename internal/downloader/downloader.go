@@ -2,195 +2,578 @@ package downloader  // 下载器包，负责HLS流下载的核心逻辑
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/MGter/hls_downloader/internal/parser"
 	"github.com/MGter/hls_downloader/internal/storage"
+	"github.com/MGter/hls_downloader/pkg/logger"
 	"github.com/MGter/hls_downloader/pkg/utils"
 )
 
 // Config 下载器配置参数
 type Config struct {
+	OutputDir              string        // 保存目录，为空时根据URL生成
+	MaxDuration            time.Duration // 录制时长上限，到达后像收到停止请求一样结束，0 表示不限制
 	MaxConcurrentDownloads int           // 最大并发下载数，同时下载几个文件
-	DownloadInterval       time.Duration // 检查新片段的时间间隔
+	DownloadInterval       time.Duration // 没有目标时长信息或出错时，检查新片段的时间间隔
+	MinReloadInterval      time.Duration // 按目标时长计算的刷新间隔下限，0 表示不限制
+	MaxReloadInterval      time.Duration // 按目标时长计算的刷新间隔上限，0 表示不限制
 	MaxRetryAttempts       int           // 下载失败时的最大重试次数
-	RetryDelayBase         time.Duration // 重试前的等待时间
+	RetryDelayBase         time.Duration // 第一次重试前的退避上限，之后每次翻倍并加随机抖动
+	RetryMaxDelay          time.Duration // 两次重试之间的最长等待时间，0 表示不限制
+	VariantSelection       VariantSelection // 主播放列表的码率版本选择策略
+	Renditions             RenditionFilter  // 需要同时录制的备用音频/字幕渲染
+	KeepEncrypted          bool             // 解密的同时保留加密原文件和密钥，用于归档
+	PrefixInitSegment      bool             // 在每个 fMP4 分片前拼接初始化片段，生成可独立播放的文件
+	ShutdownGracePeriod    time.Duration    // 停止时等待进行中的片段下载完成的最长时间，0 表示立即中断
+	SegmentIdentity        SegmentIdentity  // 片段去重使用的标识策略
+	HTTP                   utils.ClientOptions // HTTP超时和连接池设置，MaxIdleConnsPerHost 为 0 时按并发下载数设置
+	Observer               Observer            // 录制事件的接收者，nil 表示不需要事件
+	FailureThreshold       int                 // 一路媒体列表连续失败多少轮后发送 RepeatedFailure 事件，0 表示不发送
+	DiskQuota              int64               // 保存目录最多占用的字节数，超过后停止录制，0 表示不限制
 }
 
 // HLSDownloader HLS下载器结构体
 type HLSDownloader struct {
 	config    Config                  // 配置参数
+	quota     *diskQuota              // 保存目录的配额，未设置 DiskQuota 时为 nil
 	storage   *storage.FileManager    // 文件管理器，负责保存文件
 	parser    *parser.M3U8Parser      // M3U8解析器，解析播放列表
-	downloaded map[string]bool        // 记录已下载的片段，避免重复下载
+	keys      *keyCache               // 已获取的解密密钥，按URI缓存
+	client    *utils.Client           // 播放列表、密钥和片段共用的HTTP客户端
 }
 
-// New 创建下载器实例
-func New() *HLSDownloader {
-	// 设置默认配置
-	config := Config{
+// DefaultConfig 返回默认配置
+func DefaultConfig() Config {
+	return Config{
 		MaxConcurrentDownloads: 8,           // 同时下载8个文件
-		DownloadInterval:       5 * time.Second,  // 每5秒检查一次
+		DownloadInterval:       5 * time.Second,  // 无法自适应时每5秒检查一次
+		MinReloadInterval:      time.Second,      // 刷新间隔不小于1秒
+		MaxReloadInterval:      30 * time.Second, // 刷新间隔不大于30秒
+		ShutdownGracePeriod:    10 * time.Second, // 停止时最多等待10秒
 		MaxRetryAttempts:       3,           // 最多重试3次
-		RetryDelayBase:         time.Second, // 重试前等待1秒
+		RetryDelayBase:         time.Second, // 第一次重试前最多等待1秒
+		RetryMaxDelay:          30 * time.Second, // 重试间隔不大于30秒
+		VariantSelection:       VariantSelection{Policy: VariantHighest}, // 默认选择码率最高的版本
+		Renditions:             RenditionFilter{Audio: true, Subtitles: true}, // 默认录制所有音频和字幕渲染
+		SegmentIdentity:        IdentitySequence, // 按媒体序列号去重
+		HTTP:                   utils.DefaultClientOptions(), // 默认的超时和慢速传输检测
+		FailureThreshold:       3,           // 连续失败3轮时通知
 	}
+}
+
+// Validate 检查配置是否有效，返回的错误说明哪一项有问题
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.MaxConcurrentDownloads >= 1, "并发下载数必须至少为1，当前为 %d", c.MaxConcurrentDownloads)
+	check(c.MaxRetryAttempts >= 1, "最大尝试次数必须至少为1，当前为 %d", c.MaxRetryAttempts)
+	check(c.DownloadInterval > 0, "检查间隔必须大于0，当前为 %v", c.DownloadInterval)
+	check(c.RetryDelayBase >= 0, "重试等待时间不能为负数，当前为 %v", c.RetryDelayBase)
+	check(c.RetryMaxDelay >= 0, "最长重试等待时间不能为负数，当前为 %v", c.RetryMaxDelay)
+	check(c.MinReloadInterval >= 0, "最小刷新间隔不能为负数，当前为 %v", c.MinReloadInterval)
+	check(c.MaxReloadInterval >= 0, "最大刷新间隔不能为负数，当前为 %v", c.MaxReloadInterval)
+	check(c.MaxReloadInterval == 0 || c.MinReloadInterval <= c.MaxReloadInterval,
+		"最小刷新间隔 %v 大于最大刷新间隔 %v", c.MinReloadInterval, c.MaxReloadInterval)
+	check(c.MaxDuration >= 0, "录制时长上限不能为负数，当前为 %v", c.MaxDuration)
+	check(c.ShutdownGracePeriod >= 0, "停止宽限时间不能为负数，当前为 %v", c.ShutdownGracePeriod)
+	check(c.HTTP.MinThroughput >= 0, "最低传输速度不能为负数，当前为 %d", c.HTTP.MinThroughput)
+	check(c.FailureThreshold >= 0, "连续失败通知阈值不能为负数，当前为 %d", c.FailureThreshold)
+	check(c.DiskQuota >= 0, "磁盘配额不能为负数，当前为 %d", c.DiskQuota)
+
+	if _, err := ParseVariantPolicy(string(c.VariantSelection.Policy)); err != nil {
+		errs = append(errs, err)
+	}
+	if c.VariantSelection.Policy == VariantResolution {
+		check(c.VariantSelection.TargetWidth > 0 && c.VariantSelection.TargetHeight > 0, "resolution 策略需要指定目标分辨率")
+	}
+	if _, err := ParseSegmentIdentity(string(c.SegmentIdentity)); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// New 使用默认配置创建下载器实例
+func New() *HLSDownloader {
+	return NewWithConfig(DefaultConfig())
+}
+
+// NewWithConfig 使用指定配置创建下载器实例
+func NewWithConfig(config Config) *HLSDownloader {
+	client := utils.NewClient(config.clientOptions())
 
 	// 创建并返回下载器对象
 	return &HLSDownloader{
 		config:    config,
-		storage:   storage.NewFileManager(),  // 初始化文件管理器
+		storage:   storage.NewFileManagerWithOptions(storage.Options{KeepEncrypted: config.KeepEncrypted, Client: client}),  // 初始化文件管理器
 		parser:    parser.NewM3U8Parser(),    // 初始化解析器
-		downloaded: make(map[string]bool),    // 初始化已下载记录（空map）
+		keys:      newKeyCache(),             // 初始化密钥缓存
+		client:    client,                    // 共用的HTTP客户端
 	}
 }
 
-// Start 开始下载流程
-func (d *HLSDownloader) Start(m3u8URL string) error {
-	// 根据URL生成保存文件的目录名
-	outputDir, err := d.deriveOutputDir(m3u8URL)
-	if err != nil {
-		return fmt.Errorf("无法确定下载目录: %w", err)
+// clientOptions 返回HTTP客户端设置；没有指定空闲连接数时按并发下载数设置，另外留出播放列表和密钥请求的连接
+func (c Config) clientOptions() utils.ClientOptions {
+	options := c.HTTP
+	if options.MaxIdleConnsPerHost == 0 {
+		options.MaxIdleConnsPerHost = c.MaxConcurrentDownloads + 2
+	}
+	return options
+}
+
+// Start 开始下载流程；直播流会一直录制直到 ctx 被取消，VOD 或已结束的列表下载完成后返回。
+// ctx 取消后不再刷新播放列表，进行中的片段最多再下载 ShutdownGracePeriod，然后返回 nil 错误。
+// 只要开始了录制，即使返回错误也会同时返回录制结果
+func (d *HLSDownloader) Start(ctx context.Context, m3u8URL string) (*Result, error) {
+	// 未指定保存目录时根据URL生成目录名
+	outputDir := d.config.OutputDir
+	if outputDir == "" {
+		var err error
+		if outputDir, err = d.deriveOutputDir(m3u8URL); err != nil {
+			return nil, fmt.Errorf("无法确定下载目录: %w", err)
+		}
+	}
+
+	// 录制时长到达上限后按停止请求处理
+	if d.config.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.config.MaxDuration)
+		defer cancel()
+		stopLimit := context.AfterFunc(ctx, func() {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				log.Printf("已录制 %v，达到录制时长上限", d.config.MaxDuration)
+			}
+		})
+		defer stopLimit()
+	}
+
+	// 保存目录超过配额后同样按停止请求处理
+	if d.config.DiskQuota > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithCancel(ctx)
+		defer stop()
+		d.quota = newDiskQuota(outputDir, d.config.DiskQuota, stop)
 	}
 
 	// 打印开始信息
 	log.Printf("开始循环下载 HLS 流: %s", m3u8URL)
 	log.Printf("媒体片段保存目录: %s", outputDir)
 
-	// 进入主循环，开始不断下载
-	return d.loopDownloadHLS(m3u8URL, outputDir)
+	// 解析入口播放列表，得到需要录制的各路媒体列表
+	tracks, err := d.resolveTracksWithRetry(ctx, m3u8URL, outputDir)
+	if err != nil {
+		if ctx.Err() != nil {
+			return d.result(outputDir, nil), nil // 还没开始录制就被停止
+		}
+		return nil, err
+	}
+
+	names := make([]string, len(tracks))
+	for i, track := range tracks {
+		names[i] = track.name
+	}
+	d.observer().RecordingStarted(RecordingEvent{URL: m3u8URL, OutputDir: outputDir, Tracks: names})
+
+	// 下载使用独立的上下文：停止后给进行中的片段留出宽限时间
+	downloadCtx, cancelDownloads := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelDownloads()
+	stopGrace := context.AfterFunc(ctx, func() {
+		if d.config.ShutdownGracePeriod <= 0 {
+			log.Printf("收到停止请求，中断进行中的下载")
+			cancelDownloads()
+			return
+		}
+		log.Printf("收到停止请求，等待进行中的片段下载完成（最多 %v）", d.config.ShutdownGracePeriod)
+		time.AfterFunc(d.config.ShutdownGracePeriod, cancelDownloads)
+	})
+	defer stopGrace()
+
+	// 每路媒体列表单独进入主循环，并发录制
+	err = d.recordTracks(ctx, downloadCtx, tracks)
+	d.logSummary(tracks)
+	return d.result(outputDir, tracks), err
 }
 
-// loopDownloadHLS 主循环：不断检查并下载新片段
-func (d *HLSDownloader) loopDownloadHLS(m3u8URL, tempDir string) error {
+// recordTracks 为每路媒体列表启动一个主循环，等待全部结束
+func (d *HLSDownloader) recordTracks(ctx, downloadCtx context.Context, tracks []*mediaTrack) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(tracks))
+
+	for i, track := range tracks {
+		wg.Add(1)
+		go func(i int, track *mediaTrack) {
+			defer wg.Done()
+			if err := d.loopDownloadHLS(ctx, downloadCtx, track); err != nil {
+				errs[i] = fmt.Errorf("[%s] %w", track.name, err)
+			}
+		}(i, track)
+	}
+
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// loopDownloadHLS 主循环：不断检查并下载新片段。ctx 控制是否继续刷新，downloadCtx 控制进行中的请求
+func (d *HLSDownloader) loopDownloadHLS(ctx, downloadCtx context.Context, track *mediaTrack) error {
 	// 创建保存目录，权限0755表示：所有者可读写执行，其他人可读执行
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
+	if err := os.MkdirAll(track.outputDir, 0755); err != nil {
 		return fmt.Errorf("创建保存目录失败: %w", err)
 	}
 
-	// 无限循环，直到程序被停止
+	// 读取上次运行留下的状态，跳过已经下载过的片段
+	if err := d.loadResumeState(track); err != nil {
+		return err
+	}
+
+	// 循环检查，直到列表结束（ENDLIST / VOD）或程序被停止
+	endRetries := 0 // 列表结束后重试失败片段的轮数
+	failures := 0   // 连续失败的轮数
 	for {
+		// 已经收到停止请求，不再刷新列表
+		if ctx.Err() != nil {
+			log.Printf("[%s] 已停止录制", track.name)
+			return nil
+		}
+
 		// 处理M3U8文件，检查并下载新片段
-		if err := d.processM3U8(m3u8URL, tempDir); err != nil {
+		ended, err := d.processM3U8(downloadCtx, track)
+		if err != nil {
+			failures++
+			d.checkRepeatedFailure(track, failures, err)
+		} else {
+			failures = 0
+		}
+		if ended && track.window.failed() > 0 && endRetries < d.config.MaxRetryAttempts {
+			// 已结束的列表不会再滑动，失败的片段还能再试几轮
+			endRetries++
+			logger.Warn.Printf("[%s] 播放列表已结束，还有 %d 个片段下载失败，将在 %v 后第 %d 次重试",
+				track.name, track.window.failed(), d.config.DownloadInterval, endRetries)
+			sleepContext(ctx, d.config.DownloadInterval)
+			continue
+		}
+		if ended {
+			// 列表不会再增长，本轮下载完成后即可退出
+			track.ended = true
+			if err != nil {
+				err = fmt.Errorf("流已结束，但部分片段下载失败: %w", err)
+			} else {
+				log.Printf("[%s] 播放列表已结束，全部片段下载完成", track.name)
+			}
+			d.observer().StreamEnded(StreamEvent{
+				Track:  track.name,
+				URL:    track.playlistURL,
+				Queued: track.stats.queued,
+				Bytes:  track.stats.bytes,
+				Lost:   track.stats.lost + track.window.failed(),
+				Err:    err,
+			})
+			return err
+		}
+		if err != nil {
 			// 如果出错，等待后重试
-			log.Printf("处理 M3U8 文件时发生错误: %v，将在 %v 后重试", err, d.config.DownloadInterval)
+			logger.Warn.Printf("[%s] 处理 M3U8 文件时发生错误: %v，将在 %v 后重试", track.name, err, d.config.DownloadInterval)
+			d.observer().Error(ErrorEvent{Track: track.name, Err: err})
+			sleepContext(ctx, d.config.DownloadInterval)
+			continue
 		}
-		// 等待指定时间再检查一次
-		time.Sleep(d.config.DownloadInterval)
+		// 按目标时长等待后再检查一次
+		sleepContext(ctx, d.reloadDelay(track))
 	}
 }
 
-// processM3U8 处理M3U8文件的主要逻辑
-func (d *HLSDownloader) processM3U8(m3u8URL, tempDir string) error {
-	// 步骤1：下载M3U8文件内容
-	content, err := utils.HTTPGet(m3u8URL)
-	if err != nil {
-		return fmt.Errorf("下载 M3U8 文件失败: %w", err)
+// sleepContext 等待指定时间，ctx 取消时提前返回
+func sleepContext(ctx context.Context, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
 	}
+}
 
-	// 步骤2：解析M3U8内容
-	playlist, err := d.parser.Parse(content, m3u8URL)
+// processM3U8 处理媒体播放列表的主要逻辑，ended 表示列表已经结束、不会再有新片段
+func (d *HLSDownloader) processM3U8(ctx context.Context, track *mediaTrack) (ended bool, err error) {
+	// 步骤1：下载并解析M3U8文件
+	playlist, err := d.fetchPlaylist(ctx, track.playlistURL)
 	if err != nil {
-		return fmt.Errorf("解析 M3U8 失败: %w", err)
+		return false, err
 	}
 
-	// 步骤3：如果是主播放列表（包含多个子播放列表）
+	// 步骤2：媒体列表地址不应再指向主播放列表
 	if playlist.IsMaster {
-		// 检查是否有媒体播放列表
-		if len(playlist.URLs) == 0 {
-			return fmt.Errorf("主播放列表中未找到媒体列表")
+		return false, fmt.Errorf("媒体列表地址返回了主播放列表: %s", track.playlistURL)
+	}
+
+	// 出现 ENDLIST 或 VOD 类型的列表不会再变化；EVENT 类型需要继续轮询直到出现 ENDLIST
+	ended = playlist.EndList || playlist.PlaylistType == "VOD"
+	// 记录刷新状态，用于计算下次刷新时间
+	if stalled := track.reload.update(track, playlist, time.Now()); stalled > 0 {
+		d.observer().Stalled(StallEvent{
+			Track:          track.name,
+			URL:            track.playlistURL,
+			Since:          stalled,
+			TargetDuration: track.reload.targetDuration,
+		})
+	}
+	d.observer().PlaylistReloaded(PlaylistEvent{
+		Track:          track.name,
+		URL:            track.playlistURL,
+		MediaSequence:  playlist.MediaSequence,
+		Segments:       len(playlist.Segments),
+		TargetDuration: track.reload.targetDuration,
+		Changed:        track.reload.changed,
+		Ended:          ended,
+	})
+
+	// 步骤3：过滤出新的片段（还没下载过的）
+	newSegments, ids := d.filterNewSegments(track, playlist.Segments, playlist.MediaSequence)
+	if len(newSegments) == 0 {
+		if !ended {
+			log.Printf("[%s] 未发现新片段，等待下次检查", track.name)
 		}
-		// 选择第一个媒体播放列表继续处理
-		selectedMediaURL := playlist.URLs[0]
-		log.Printf("发现主播放列表，切换到媒体列表: %s", selectedMediaURL)
-		// 递归处理媒体播放列表
-		return d.processM3U8(selectedMediaURL, tempDir)
+		return ended, nil  // 没有新片段，直接返回
+	}
+
+	// 步骤4：准备下载任务（包括获取解密密钥）
+	tasks, err := d.buildTasks(ctx, track, newSegments)
+	if err != nil {
+		return ended, fmt.Errorf("准备下载任务失败: %w", err)
+	}
+
+	// 步骤5：并发下载新片段，等待全部完成后返回
+	log.Printf("[%s] 发现 %d 个新片段，开始下载", track.name, len(tasks))
+	track.stats.queued += len(tasks)
+	for _, id := range ids {
+		track.window.start(id)
+	}
+	results, err := d.concurrentDownload(ctx, tasks, track.outputDir, d.downloadHooks(track, newSegments))
+	// 按结果更新每个片段的状态，失败的片段在下次刷新列表时重试
+	for i, result := range results {
+		track.window.finish(ids[i], result.Err)
+	}
+	// 无论本批是否全部成功，都先记录已经完成的片段
+	saved := track.stats.bytes
+	d.saveResumeState(track, ids, results)
+	d.checkQuota(track.stats.bytes - saved)
+	if err != nil {
+		track.stats.failedBatches++
+		d.recordFailures(track, err)
+		return ended, fmt.Errorf("并发下载新 TS 文件失败: %w", err)
+	}
+
+	return ended, nil
+}
+
+// recordFailures 逐个输出失败片段的详情，并按失败分类计数
+func (d *HLSDownloader) recordFailures(track *mediaTrack, err error) {
+	var batch *storage.BatchError
+	if !errors.As(err, &batch) {
+		return
+	}
+	for _, f := range batch.Failures {
+		logger.Warn.Printf("[%s] 片段 #%d 下载失败: 尝试 %d 次, 状态码 %d, 已接收 %d 字节, 类型 %s, 原因: %v",
+			track.name, f.Sequence, f.Attempts, f.StatusCode, f.BytesReceived, f.Class, f.Err)
+		track.stats.recordFailure(f.Class)
 	}
+}
 
-	// 步骤4：过滤出新的片段（还没下载过的）
-	newTSURLs := d.filterNewSegments(playlist.URLs, playlist.MediaSequence)
-	if len(newTSURLs) == 0 {
-		log.Printf("未发现新片段，等待下次检查")
-		return nil  // 没有新片段，直接返回
+// checkRepeatedFailure 连续失败的轮数达到阈值时通知一次，恢复成功后重新计数
+func (d *HLSDownloader) checkRepeatedFailure(track *mediaTrack, failures int, err error) {
+	if d.config.FailureThreshold <= 0 || failures != d.config.FailureThreshold {
+		return
 	}
+	logger.Error.Printf("[%s] 已连续 %d 轮失败: %v", track.name, failures, err)
+	d.observer().RepeatedFailure(FailureEvent{Track: track.name, URL: track.playlistURL, Count: failures, Err: err})
+}
 
-	// 步骤5：并发下载新片段
-	log.Printf("发现 %d 个新片段，开始下载", len(newTSURLs))
-	if err := d.concurrentDownload(newTSURLs, tempDir); err != nil {
-		return fmt.Errorf("并发下载新 TS 文件失败: %w", err)
+// fetchPlaylist 下载并解析M3U8文件
+func (d *HLSDownloader) fetchPlaylist(ctx context.Context, m3u8URL string) (*parser.Playlist, error) {
+	// 下载M3U8文件内容
+	content, err := d.client.Get(ctx, utils.KindPlaylist, m3u8URL, d.retryPolicy())
+	if err != nil {
+		return nil, fmt.Errorf("下载 M3U8 文件失败: %w", err)
 	}
 
-	return nil
+	// 解析M3U8内容
+	playlist, err := d.parser.Parse(content, m3u8URL)
+	if err != nil {
+		return nil, fmt.Errorf("解析 M3U8 失败: %w", err)
+	}
+
+	return playlist, nil
 }
 
-// filterNewSegments 过滤出新片段（还没下载过的）
-func (d *HLSDownloader) filterNewSegments(tsURLs []string, mediaSeq int) []string {
+// filterNewSegments 过滤出新片段（还没下载过的），同时返回与之对应的片段ID
+func (d *HLSDownloader) filterNewSegments(track *mediaTrack, segments []parser.Segment, mediaSeq int) ([]parser.Segment, []string) {
 	// 如果没有片段，返回空
-	if len(tsURLs) == 0 {
-		return nil
+	if len(segments) == 0 {
+		return nil, nil
 	}
 
-	var newURLs []string  // 存储新片段的URL
+	var newSegments []parser.Segment  // 存储新片段
+	var ids []string                  // 新片段的ID
+	retried := 0                      // 之前下载失败、本次重试的片段数
+
+	// 按列表起始序列号移动去重窗口，没下载成功就滑出列表的片段再也无法获取
+	reset, lost := track.window.advance(mediaSeq, len(segments))
+	if reset {
+		log.Printf("[%s] 媒体序列号回退到 %d，判断为流重新开始，清空去重窗口", track.name, mediaSeq)
+	}
+	for _, l := range lost {
+		logger.Warn.Printf("[%s] 片段 #%d 没有下载成功就滑出了播放列表，已永久丢失: %s", track.name, l.seq, l.uri)
+	}
+	track.recordLost(lost)
 	
 	// 统计信息
 	var stats = struct {
 		invalidURL, invalidName, downloaded int
 	}{}
 
-	// 遍历所有片段URL
-	for index, urlStr := range tsURLs {
+	// 遍历所有片段
+	for index, segment := range segments {
 		// 处理单个片段URL，获取片段ID
-		segmentID, skip := d.processSegmentURL(urlStr, mediaSeq, index, &stats)
+		segmentID, skip := d.processSegmentURL(segment, mediaSeq, index, &stats)
 		if skip {
 			continue  // 跳过这个片段
 		}
 
-		// 检查是否已下载
-		if d.downloaded[segmentID] {
+		// 检查是否已下载或正在下载
+		if !track.window.needsDownload(segmentID, segment.MediaSequence) {
 			stats.downloaded++  // 已下载计数
 			continue
 		}
+		if track.window.retrying(segmentID) {
+			retried++
+		}
 
-		// 是新片段，添加到下载列表
-		newURLs = append(newURLs, urlStr)
-		// 标记为已下载，避免下次重复下载
-		d.downloaded[segmentID] = true
+		// 是新片段或需要重试的片段，添加到下载列表
+		newSegments = append(newSegments, segment)
+		ids = append(ids, segmentID)
+		// 记录到窗口中，下载成功后才会标记为已下载
+		track.window.add(segmentID, segment.MediaSequence, segment.URI)
 	}
 
 	// 打印过滤结果
-	log.Printf("片段过滤完成: 总计%d个, 新增%d个, 无效URL%d个, 无效文件名%d个, 已下载%d个",
-		len(tsURLs), len(newURLs), stats.invalidURL, stats.invalidName, stats.downloaded)
+	log.Printf("[%s] 片段过滤完成: 总计%d个, 新增%d个（其中重试%d个）, 无效URL%d个, 无效文件名%d个, 已下载%d个, 去重窗口%d个",
+		track.name, len(segments), len(newSegments), retried, stats.invalidURL, stats.invalidName, stats.downloaded, track.window.len())
 
-	return newURLs
+	return newSegments, ids
 }
 
-// processSegmentURL 处理单个片段URL，提取片段ID
-func (d *HLSDownloader) processSegmentURL(urlStr string, mediaSeq, index int, stats *struct{ invalidURL, invalidName, downloaded int }) (string, bool) {
+// processSegmentURL 处理单个片段URL，按配置的策略得到片段ID
+func (d *HLSDownloader) processSegmentURL(segment parser.Segment, mediaSeq, index int, stats *struct{ invalidURL, invalidName, downloaded int }) (string, bool) {
+	// 默认按序列号标识，与片段的文件名无关
+	if d.config.SegmentIdentity != IdentityFilename {
+		return segment.ID(), false
+	}
+
+	urlStr := segment.URI
 	// 从URL中提取片段ID（唯一标识）
 	segmentID, err := d.parser.ExtractSegmentID(urlStr, mediaSeq, index)
 	if err != nil {
-		log.Printf("无效URL已跳过 [索引%d]: %s, 错误: %v", index, urlStr, err)
+		logger.Warn.Printf("无效URL已跳过 [索引%d]: %s, 错误: %v", index, urlStr, err)
 		stats.invalidURL++  // 无效URL计数
 		return "", true     // 返回true表示跳过
 	}
 
 	// 检查片段ID是否为空
 	if segmentID == "" {
-		log.Printf("无效文件名已跳过 [索引%d]: %s", index, urlStr)
+		logger.Warn.Printf("无效文件名已跳过 [索引%d]: %s", index, urlStr)
 		stats.invalidName++  // 无效文件名计数
 		return "", true      // 返回true表示跳过
 	}
 
+	// 同一个资源的不同字节范围是不同的片段
+	if segment.ByteRange != nil {
+		segmentID = fmt.Sprintf("%s@%d", segmentID, segment.ByteRange.Offset)
+	}
+
 	return segmentID, false  // 返回片段ID，false表示不跳过
 }
 
+// buildTasks 把片段转换为下载任务，加密片段会带上密钥和IV
+func (d *HLSDownloader) buildTasks(ctx context.Context, track *mediaTrack, segments []parser.Segment) ([]storage.SegmentTask, error) {
+	tasks := make([]storage.SegmentTask, 0, len(segments))
+
+	for _, segment := range segments {
+		task := storage.SegmentTask{
+			URL:      segment.URI,
+			Sequence: segment.MediaSequence,
+		}
+		if br := segment.ByteRange; br != nil {
+			task.ByteRange = &storage.ByteRange{Offset: br.Offset, Length: br.Length}
+		}
+
+		if segment.Key != nil {
+			decryption, err := d.resolveDecryption(ctx, track, segment)
+			if err != nil {
+				return nil, fmt.Errorf("片段 %d: %w", segment.MediaSequence, err)
+			}
+			task.Decryption = decryption
+		}
+
+		// fMP4/CMAF 分片：EXT-X-MAP 变化时下载新的初始化片段
+		if segment.Map != nil {
+			initData, err := d.ensureInitSegment(ctx, track, segment, task.Decryption)
+			if err != nil {
+				return nil, fmt.Errorf("片段 %d: %w", segment.MediaSequence, err)
+			}
+			task.Fragmented = true
+			if d.config.PrefixInitSegment {
+				task.InitData = initData
+			}
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
 // concurrentDownload 并发下载多个片段
-func (d *HLSDownloader) concurrentDownload(tsURLs []string, tempDir string) error {
-	ctx := context.Background()  // 创建上下文
+func (d *HLSDownloader) concurrentDownload(ctx context.Context, tasks []storage.SegmentTask, tempDir string, hooks storage.DownloadHooks) ([]storage.DownloadResult, error) {
 	// 调用存储器的并发下载功能
-	return d.storage.ConcurrentDownload(ctx, tsURLs, tempDir, d.config.MaxConcurrentDownloads, d.config.MaxRetryAttempts)
+	return d.storage.ConcurrentDownload(ctx, tasks, tempDir, d.config.MaxConcurrentDownloads, d.retryPolicy(), hooks)
+}
+
+// retryPolicy 根据配置生成片段、播放列表和密钥请求共用的重试策略
+func (d *HLSDownloader) retryPolicy() utils.RetryPolicy {
+	return utils.RetryPolicy{
+		MaxAttempts: d.config.MaxRetryAttempts,
+		BaseDelay:   d.config.RetryDelayBase,
+		MaxDelay:    d.config.RetryMaxDelay,
+	}
+}
+
+// logSummary 输出每路媒体列表的录制汇总，并列出最终没有下载成功的片段
+func (d *HLSDownloader) logSummary(tracks []*mediaTrack) {
+	for _, track := range tracks {
+		// 结束时仍在窗口中但没有下载成功的片段同样算作丢失
+		track.recordLost(track.window.unfinished())
+
+		log.Printf("[%s] 录制汇总: 下载片段 %d 个, 共 %d 字节, 失败批次 %d 个, 片段失败 %d 次, 丢失片段 %d 个, 保存目录 %s",
+			track.name, track.stats.queued, track.stats.bytes, track.stats.failedBatches, track.stats.failures, track.stats.lost, track.outputDir)
+		if track.stats.failures > 0 {
+			logger.Warn.Printf("[%s] 失败分类: %s", track.name, track.stats.failureSummary())
+		}
+		for _, lost := range track.lost {
+			logger.Warn.Printf("[%s] 丢失片段 #%d（尝试 %d 轮）: %s, 原因: %v", track.name, lost.seq, lost.attempts, lost.uri, lost.lastErr)
+		}
+		if omitted := track.stats.lost - len(track.lost); omitted > 0 {
+			logger.Warn.Printf("[%s] 另有 %d 个丢失片段未列出", track.name, omitted)
+		}
+	}
 }
 
 // deriveOutputDir 根据URL生成输出目录名
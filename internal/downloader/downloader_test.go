@@ -0,0 +1,17 @@
+package downloader
+
+import "testing"
+
+// 默认配置不指定空闲连接数，连接池跟随并发下载数；显式指定时保持不变
+func TestClientOptionsIdlePoolFollowsConcurrency(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConcurrentDownloads = 32
+	if got := config.clientOptions().MaxIdleConnsPerHost; got != 34 {
+		t.Errorf("concurrency 32: MaxIdleConnsPerHost %d, want 34", got)
+	}
+
+	config.HTTP.MaxIdleConnsPerHost = 4
+	if got := config.clientOptions().MaxIdleConnsPerHost; got != 4 {
+		t.Errorf("explicit pool size: MaxIdleConnsPerHost %d, want 4", got)
+	}
+}
@@ -0,0 +1,281 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/MGter/hls_downloader/internal/parser"
+	"github.com/MGter/hls_downloader/internal/storage"
+)
+
+// Observer 接收录制过程中的事件。回调在录制协程和片段下载协程中同步调用，
+// 多路媒体列表和同一批的多个片段会并发回调；耗时的处理应交给其他协程，否则会拖慢录制
+type Observer interface {
+	RecordingStarted(RecordingEvent) // 解析出要录制的媒体列表，开始录制
+	PlaylistReloaded(PlaylistEvent)  // 成功获取并解析了一次媒体播放列表
+	VariantSwitched(VariantEvent)    // 从主播放列表切换到选中的码率版本
+	SegmentStarted(SegmentEvent)     // 片段开始下载，File 为将要保存的路径
+	SegmentCompleted(SegmentEvent)   // 片段已保存到磁盘
+	SegmentFailed(SegmentEvent)      // 片段重试用尽仍下载失败，下次刷新列表时还会再试
+	Discontinuity(SegmentEvent)      // 遇到 EXT-X-DISCONTINUITY，事件中是不连续点之后的第一个片段，在它保存后发送
+	StreamEnded(StreamEvent)         // 播放列表已结束（ENDLIST / VOD），这一路不会再有新片段
+	Stalled(StallEvent)              // 播放列表超过 1.5 倍目标时长没有更新，每轮停滞只通知一次
+	RepeatedFailure(FailureEvent)    // 连续多轮刷新或下载失败，达到 FailureThreshold 时通知一次
+	DiskQuotaExceeded(QuotaEvent)    // 保存目录的占用超过 DiskQuota，录制随后停止
+	Error(ErrorEvent)                // 片段之外的错误，例如播放列表获取失败
+}
+
+// RecordingEvent 开始录制
+type RecordingEvent struct {
+	URL       string   // 入口播放列表地址
+	OutputDir string   // 保存目录
+	Tracks    []string // 要录制的媒体列表名称
+}
+
+// PlaylistEvent 一次媒体播放列表刷新
+type PlaylistEvent struct {
+	Track          string        // 媒体列表名称
+	URL            string        // 媒体播放列表地址
+	MediaSequence  int           // 列表第一个片段的媒体序列号
+	Segments       int           // 列表中的片段数
+	TargetDuration time.Duration // EXT-X-TARGETDURATION
+	Changed        bool          // 与上一次刷新相比列表是否有变化
+	Ended          bool          // 列表已经结束（ENDLIST / VOD）
+}
+
+// VariantEvent 选中的码率版本
+type VariantEvent struct {
+	MasterURL string        // 主播放列表地址
+	URI       string        // 选中的媒体播放列表地址
+	Policy    VariantPolicy // 使用的选择策略
+	Variants  int           // 主播放列表中的码率版本数
+	Bandwidth int           // BANDWIDTH（bit/s）
+	Width     int           // 分辨率宽度，0 表示未提供
+	Height    int           // 分辨率高度，0 表示未提供
+	Codecs    []string      // CODECS
+}
+
+// SegmentEvent 单个片段的元数据和下载结果
+type SegmentEvent struct {
+	Track                 string        // 媒体列表名称
+	URI                   string        // 片段地址
+	Sequence              int           // 媒体序列号
+	DiscontinuitySequence int           // 不连续序列号
+	Discontinuity         bool          // 片段前是否有 EXT-X-DISCONTINUITY
+	Duration              time.Duration // EXTINF 时长
+	ProgramDateTime       time.Time     // 片段第一帧的绝对时间，零值表示未知
+	File                  string        // 保存路径，失败时为空
+	Size                  int64         // 写入文件的字节数
+	Checksum              string        // 文件内容的 SHA-256（十六进制）
+	Attempts              int           // 实际发出的请求次数
+	Err                   error         // 失败原因（*storage.SegmentError），成功时为 nil
+}
+
+// StreamEvent 一路媒体列表结束
+type StreamEvent struct {
+	Track  string // 媒体列表名称
+	URL    string // 媒体播放列表地址
+	Queued int    // 提交下载的片段数
+	Bytes  int64  // 已保存的字节数
+	Lost   int    // 永久丢失和最终没有下载成功的片段数
+	Err    error  // 部分片段最终没有下载成功时的错误，全部成功时为 nil
+}
+
+// StallEvent 播放列表停止更新
+type StallEvent struct {
+	Track          string        // 媒体列表名称
+	URL            string        // 媒体播放列表地址
+	Since          time.Duration // 已经多久没有更新
+	TargetDuration time.Duration // EXT-X-TARGETDURATION
+}
+
+// FailureEvent 一路媒体列表连续失败
+type FailureEvent struct {
+	Track string // 媒体列表名称
+	URL   string // 媒体播放列表地址
+	Count int    // 连续失败的轮数
+	Err   error  // 最近一次的错误
+}
+
+// QuotaEvent 保存目录的占用超过配额
+type QuotaEvent struct {
+	OutputDir string // 保存目录
+	Limit     int64  // 配额（字节）
+	Used      int64  // 当前占用（字节）
+}
+
+// ErrorEvent 录制过程中片段之外的错误，录制会在等待后继续
+type ErrorEvent struct {
+	Track string // 媒体列表名称
+	Err   error  // 错误详情
+}
+
+// nopObserver 没有配置 Observer 时使用，忽略所有事件
+type nopObserver struct{}
+
+func (nopObserver) RecordingStarted(RecordingEvent) {}
+func (nopObserver) PlaylistReloaded(PlaylistEvent)  {}
+func (nopObserver) VariantSwitched(VariantEvent)    {}
+func (nopObserver) SegmentStarted(SegmentEvent)     {}
+func (nopObserver) SegmentCompleted(SegmentEvent)   {}
+func (nopObserver) SegmentFailed(SegmentEvent)      {}
+func (nopObserver) Discontinuity(SegmentEvent)      {}
+func (nopObserver) StreamEnded(StreamEvent)         {}
+func (nopObserver) Stalled(StallEvent)              {}
+func (nopObserver) RepeatedFailure(FailureEvent)    {}
+func (nopObserver) DiskQuotaExceeded(QuotaEvent)    {}
+func (nopObserver) Error(ErrorEvent)                {}
+
+// multiObserver 把事件依次交给多个 Observer
+type multiObserver []Observer
+
+// MultiObserver 组合多个 Observer，nil 会被忽略；Close 时关闭其中实现了 io.Closer 的 Observer
+func MultiObserver(observers ...Observer) Observer {
+	var list multiObserver
+	for _, o := range observers {
+		if o != nil {
+			list = append(list, o)
+		}
+	}
+	switch len(list) {
+	case 0:
+		return nil
+	case 1:
+		return list[0]
+	}
+	return list
+}
+
+func (m multiObserver) RecordingStarted(e RecordingEvent) {
+	for _, o := range m {
+		o.RecordingStarted(e)
+	}
+}
+
+func (m multiObserver) PlaylistReloaded(e PlaylistEvent) {
+	for _, o := range m {
+		o.PlaylistReloaded(e)
+	}
+}
+
+func (m multiObserver) VariantSwitched(e VariantEvent) {
+	for _, o := range m {
+		o.VariantSwitched(e)
+	}
+}
+
+func (m multiObserver) SegmentStarted(e SegmentEvent) {
+	for _, o := range m {
+		o.SegmentStarted(e)
+	}
+}
+
+func (m multiObserver) SegmentCompleted(e SegmentEvent) {
+	for _, o := range m {
+		o.SegmentCompleted(e)
+	}
+}
+
+func (m multiObserver) SegmentFailed(e SegmentEvent) {
+	for _, o := range m {
+		o.SegmentFailed(e)
+	}
+}
+
+func (m multiObserver) Discontinuity(e SegmentEvent) {
+	for _, o := range m {
+		o.Discontinuity(e)
+	}
+}
+
+func (m multiObserver) StreamEnded(e StreamEvent) {
+	for _, o := range m {
+		o.StreamEnded(e)
+	}
+}
+
+func (m multiObserver) Stalled(e StallEvent) {
+	for _, o := range m {
+		o.Stalled(e)
+	}
+}
+
+func (m multiObserver) RepeatedFailure(e FailureEvent) {
+	for _, o := range m {
+		o.RepeatedFailure(e)
+	}
+}
+
+func (m multiObserver) DiskQuotaExceeded(e QuotaEvent) {
+	for _, o := range m {
+		o.DiskQuotaExceeded(e)
+	}
+}
+
+func (m multiObserver) Error(e ErrorEvent) {
+	for _, o := range m {
+		o.Error(e)
+	}
+}
+
+// Close 关闭其中实现了 io.Closer 的 Observer
+func (m multiObserver) Close() error {
+	var errs []error
+	for _, o := range m {
+		if closer, ok := o.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// observer 返回配置的事件接收者，没有配置时返回忽略事件的实现
+func (d *HLSDownloader) observer() Observer {
+	if d.config.Observer == nil {
+		return nopObserver{}
+	}
+	return d.config.Observer
+}
+
+// segmentEvent 根据片段信息生成事件，下载结果由调用方补充
+func segmentEvent(track *mediaTrack, segment parser.Segment) SegmentEvent {
+	return SegmentEvent{
+		Track:                 track.name,
+		URI:                   segment.URI,
+		Sequence:              segment.MediaSequence,
+		DiscontinuitySequence: segment.DiscontinuitySequence,
+		Discontinuity:         segment.Discontinuity,
+		Duration:              time.Duration(segment.Duration * float64(time.Second)),
+		ProgramDateTime:       segment.ProgramDateTime,
+	}
+}
+
+// downloadHooks 生成一批片段的下载回调，把开始、完成和失败转换为事件
+func (d *HLSDownloader) downloadHooks(track *mediaTrack, segments []parser.Segment) storage.DownloadHooks {
+	observer := d.observer()
+	return storage.DownloadHooks{
+		Started: func(index int, filename string) {
+			event := segmentEvent(track, segments[index])
+			event.File = filename
+			observer.SegmentStarted(event)
+		},
+		Finished: func(index int, result *storage.DownloadResult) {
+			event := segmentEvent(track, segments[index])
+			event.Attempts = result.Attempts
+			if result.Err != nil {
+				event.Err = result.Err
+				observer.SegmentFailed(event)
+				return
+			}
+			event.File = result.Filename
+			event.Size = result.Size
+			event.Checksum = result.Checksum
+			// 不连续点在第一个片段保存后通知，事件中带上文件路径；失败重试的片段只会成功一次
+			if event.Discontinuity {
+				observer.Discontinuity(event)
+			}
+			observer.SegmentCompleted(event)
+		},
+	}
+}
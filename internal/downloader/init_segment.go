@@ -0,0 +1,64 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+
+	"github.com/MGter/hls_downloader/internal/parser"
+	"github.com/MGter/hls_downloader/internal/storage"
+)
+
+// initSegmentState 一路媒体列表当前使用的初始化片段
+type initSegmentState struct {
+	key  string // URI 和字节范围组成的标识，变化时需要重新下载
+	data []byte // 初始化片段内容，用于拼接到分片前面
+}
+
+// ensureInitSegment 片段的 EXT-X-MAP 发生变化时下载新的初始化片段，返回当前初始化片段内容
+func (d *HLSDownloader) ensureInitSegment(ctx context.Context, track *mediaTrack, segment parser.Segment, decryption *storage.Decryption) ([]byte, error) {
+	segMap := segment.Map
+	key := segMap.URI
+	if br := segMap.ByteRange; br != nil {
+		key = fmt.Sprintf("%s@%d+%d", segMap.URI, br.Offset, br.Length)
+	}
+	if key == track.init.key {
+		return track.init.data, nil // 没有变化，沿用已下载的初始化片段
+	}
+
+	task := storage.SegmentTask{URL: segMap.URI}
+	if br := segMap.ByteRange; br != nil {
+		task.ByteRange = &storage.ByteRange{Offset: br.Offset, Length: br.Length}
+	}
+	// 规范要求加密的初始化片段必须显式给出IV，没有IV时视为未加密
+	if decryption != nil && segment.Key.IV != nil {
+		task.Decryption = decryption
+	}
+
+	name := initSegmentName(segMap.URI, key)
+	data, err := d.storage.DownloadInitSegment(ctx, task, track.outputDir, name, d.retryPolicy())
+	if err != nil {
+		return nil, fmt.Errorf("下载初始化片段失败: %w", err)
+	}
+
+	track.init = initSegmentState{key: key, data: data}
+	log.Printf("[%s] 初始化片段已保存: %s (%d 字节)", track.name, name, len(data))
+	return data, nil
+}
+
+// initSegmentName 生成初始化片段的文件名，例如 init_3f2a9c0d1e4b5a67_init.mp4。
+// 用 URI 和字节范围的哈希区分不同的初始化片段，续录时同一个初始化片段得到相同的文件名，不会覆盖其他初始化片段
+func initSegmentName(uri, key string) string {
+	base := "init.mp4"
+	if parsed, err := url.Parse(uri); err == nil {
+		if name := path.Base(parsed.Path); name != "" && name != "." && name != "/" {
+			base = name
+		}
+	}
+	sum := sha1.Sum([]byte(key))
+	return fmt.Sprintf("init_%s_%s", hex.EncodeToString(sum[:8]), base)
+}
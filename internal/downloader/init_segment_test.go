@@ -0,0 +1,78 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MGter/hls_downloader/internal/parser"
+)
+
+// 初始化片段的文件名只由 URI 和字节范围决定：不同的初始化片段不会互相覆盖，
+// 续录时（初始化片段状态为空）同一个初始化片段得到相同的文件名
+func TestEnsureInitSegmentNames(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"+r.URL.Path))
+	}))
+	defer server.Close()
+
+	d := New()
+	dir := t.TempDir()
+	maps := []*parser.Map{
+		{URI: server.URL + "/a/init.mp4"},
+		{URI: server.URL + "/b/init.mp4"},
+		{URI: server.URL + "/a/init.mp4", ByteRange: &parser.ByteRange{Offset: 0, Length: 4}},
+		{URI: server.URL + "/a/init.mp4", ByteRange: &parser.ByteRange{Offset: 4, Length: 4}},
+	}
+	want := []string{"0123456789/a/init.mp4", "0123456789/b/init.mp4", "0123", "4567"}
+
+	// download 依次使用 indexes 指定的初始化片段，返回保存目录中的文件名和内容
+	download := func(track *mediaTrack, indexes ...int) map[string]string {
+		t.Helper()
+		files := make(map[string]string)
+		for _, i := range indexes {
+			data, err := d.ensureInitSegment(context.Background(), track, parser.Segment{Map: maps[i]}, nil)
+			if err != nil {
+				t.Fatalf("map %d: %v", i, err)
+			}
+			if string(data) != want[i] {
+				t.Errorf("map %d: data %q, want %q", i, data, want[i])
+			}
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			data, _ := os.ReadFile(filepath.Join(dir, e.Name()))
+			files[e.Name()] = string(data)
+		}
+		return files
+	}
+
+	first := download(&mediaTrack{name: "main", outputDir: dir}, 0, 1, 2, 3)
+	if len(first) != len(maps) {
+		t.Fatalf("got %d init segment files %v, want %d", len(first), first, len(maps))
+	}
+	for name := range first {
+		if !strings.HasPrefix(name, "init_") || !strings.HasSuffix(name, "_init.mp4") {
+			t.Errorf("unexpected file name %q", name)
+		}
+	}
+
+	// 模拟重启：新的 mediaTrack 没有初始化片段状态，列表从后面的初始化片段开始
+	second := download(&mediaTrack{name: "main", outputDir: dir}, 3, 1)
+	if len(second) != len(first) {
+		t.Fatalf("after restart got %d files, want %d", len(second), len(first))
+	}
+	for name, data := range first {
+		if second[name] != data {
+			t.Errorf("after restart %s = %q, want %q", name, second[name], data)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/MGter/hls_downloader/internal/parser"
+	"github.com/MGter/hls_downloader/internal/storage"
+	"github.com/MGter/hls_downloader/pkg/logger"
+	"github.com/MGter/hls_downloader/pkg/utils"
+)
+
+// maxCachedKeys 密钥缓存最多保留的密钥数。轮换密钥的直播流每隔一段时间换一个 URI，
+// 只需要保留当前列表窗口内还在使用的几个，更早的密钥按最近最少使用淘汰
+const maxCachedKeys = 16
+
+// keyCache 解密密钥缓存，同一个密钥URI同时只下载一次，最多保留 maxCachedKeys 个
+type keyCache struct {
+	mu       sync.Mutex              // 保护以下字段，多路媒体列表会并发访问
+	keys     map[string]*cachedKey   // 密钥URI -> 密钥
+	inflight map[string]*keyDownload // 正在下载的密钥URI，其他请求等待同一次下载
+	clock    uint64                  // 每次访问加一，用于按最近使用时间淘汰
+}
+
+// cachedKey 缓存中的一个密钥
+type cachedKey struct {
+	key  []byte // 16字节密钥
+	used uint64 // 最近一次访问时的 clock
+}
+
+// keyDownload 一次正在进行的密钥下载
+type keyDownload struct {
+	done chan struct{} // 下载结束后关闭
+	key  []byte        // 下载到的密钥
+	err  error         // 下载失败的原因
+}
+
+// newKeyCache 创建空的密钥缓存
+func newKeyCache() *keyCache {
+	return &keyCache{keys: make(map[string]*cachedKey), inflight: make(map[string]*keyDownload)}
+}
+
+// get 返回密钥，缓存中没有时用 client 按 policy 从服务器下载；fresh 表示本次是新下载的。
+// 下载时不持有锁，不同的密钥可以并行下载，同一个密钥的并发请求共用一次下载
+func (c *keyCache) get(ctx context.Context, client *utils.Client, keyURI string, policy utils.RetryPolicy) (key []byte, fresh bool, err error) {
+	c.mu.Lock()
+	if cached, ok := c.keys[keyURI]; ok {
+		c.clock++
+		cached.used = c.clock
+		c.mu.Unlock()
+		return cached.key, false, nil
+	}
+	if call, ok := c.inflight[keyURI]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.key, false, call.err
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+	call := &keyDownload{done: make(chan struct{})}
+	c.inflight[keyURI] = call
+	c.mu.Unlock()
+
+	call.key, call.err = fetchKey(ctx, client, keyURI, policy)
+
+	c.mu.Lock()
+	delete(c.inflight, keyURI)
+	if call.err == nil {
+		c.put(keyURI, call.key)
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.key, call.err == nil, call.err
+}
+
+// put 把密钥放入缓存，超过 maxCachedKeys 时淘汰最久没有使用的密钥，调用方需持有锁
+func (c *keyCache) put(keyURI string, key []byte) {
+	c.clock++
+	c.keys[keyURI] = &cachedKey{key: key, used: c.clock}
+	if len(c.keys) <= maxCachedKeys {
+		return
+	}
+
+	oldest := ""
+	for uri, cached := range c.keys {
+		if oldest == "" || cached.used < c.keys[oldest].used {
+			oldest = uri
+		}
+	}
+	delete(c.keys, oldest)
+}
+
+// fetchKey 从服务器下载密钥并检查长度
+func fetchKey(ctx context.Context, client *utils.Client, keyURI string, policy utils.RetryPolicy) ([]byte, error) {
+	key, err := client.GetBytes(ctx, utils.KindKey, keyURI, policy)
+	if err != nil {
+		return nil, fmt.Errorf("下载密钥失败: %w", err)
+	}
+	// AES-128 / SAMPLE-AES 密钥固定为16字节
+	if len(key) != 16 {
+		return nil, fmt.Errorf("密钥长度应为16字节，实际为%d字节: %s", len(key), keyURI)
+	}
+	return key, nil
+}
+
+// resolveDecryption 根据片段的 EXT-X-KEY 准备解密参数
+func (d *HLSDownloader) resolveDecryption(ctx context.Context, track *mediaTrack, segment parser.Segment) (*storage.Decryption, error) {
+	key := segment.Key
+
+	// 只支持明文下发的密钥，DRM 系统的 KEYFORMAT 无法解密
+	if key.KeyFormat != "identity" {
+		return nil, fmt.Errorf("不支持的 KEYFORMAT: %s", key.KeyFormat)
+	}
+	if key.Method != "AES-128" && key.Method != "SAMPLE-AES" {
+		return nil, fmt.Errorf("不支持的加密方式: %s", key.Method)
+	}
+	// SAMPLE-AES 只支持 MPEG-TS 片段，fMP4 片段使用 CENC 的 cbcs 方案加密，这里无法解密
+	if key.Method == "SAMPLE-AES" && segment.Map != nil {
+		return nil, fmt.Errorf("不支持 fMP4 片段（EXT-X-MAP）的 SAMPLE-AES 加密，只支持 MPEG-TS 片段")
+	}
+	if key.URI == "" {
+		return nil, fmt.Errorf("EXT-X-KEY 缺少 URI")
+	}
+
+	keyBytes, fresh, err := d.keys.get(ctx, d.client, key.URI, d.retryPolicy())
+	if err != nil {
+		return nil, err
+	}
+
+	// 需要归档时，新密钥同时保存到输出目录
+	if fresh && d.config.KeepEncrypted {
+		if path, err := d.storage.SaveKey(track.outputDir, key.URI, keyBytes); err != nil {
+			logger.Warn.Printf("[%s] 保存密钥失败: %v", track.name, err)
+		} else {
+			log.Printf("[%s] 密钥已保存: %s", track.name, path)
+		}
+	}
+
+	return &storage.Decryption{
+		Method: key.Method,
+		Key:    keyBytes,
+		IV:     segmentIV(key, segment.MediaSequence),
+	}, nil
+}
+
+// segmentIV 返回片段的IV：优先使用显式IV，否则按规范用媒体序列号的128位大端表示
+func segmentIV(key *parser.Key, mediaSequence int) []byte {
+	if key.IV != nil {
+		return key.IV
+	}
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint64(iv[8:], uint64(mediaSequence))
+	return iv
+}
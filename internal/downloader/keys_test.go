@@ -0,0 +1,178 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MGter/hls_downloader/internal/parser"
+	"github.com/MGter/hls_downloader/pkg/utils"
+)
+
+// keyServer 返回16字节密钥并按路径统计请求次数；/slow 会一直等到 release 关闭
+type keyServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	hits    map[string]int
+	release chan struct{}
+}
+
+func newKeyServer(t *testing.T) *keyServer {
+	s := &keyServer{hits: make(map[string]int), release: make(chan struct{})}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.hits[r.URL.Path]++
+		s.mu.Unlock()
+		switch r.URL.Path {
+		case "/slow":
+			<-s.release
+		case "/shared":
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Write(bytes.Repeat([]byte{byte(len(r.URL.Path))}, 16))
+	}))
+	t.Cleanup(s.Close)
+	t.Cleanup(func() {
+		select {
+		case <-s.release:
+		default:
+			close(s.release)
+		}
+	})
+	return s
+}
+
+func (s *keyServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+var testPolicy = utils.RetryPolicy{MaxAttempts: 1}
+
+// 同一个密钥的并发请求只下载一次，只有发起下载的请求得到 fresh
+func TestKeyCacheSingleflight(t *testing.T) {
+	server := newKeyServer(t)
+	client := utils.NewClient(utils.DefaultClientOptions())
+	cache := newKeyCache()
+
+	var wg sync.WaitGroup
+	var fresh atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, isFresh, err := cache.get(context.Background(), client, server.URL+"/shared", testPolicy)
+			if err != nil || len(key) != 16 {
+				t.Errorf("get: key %x, err %v", key, err)
+			}
+			if isFresh {
+				fresh.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := server.count("/shared"); n != 1 {
+		t.Errorf("key server hit %d times, want 1", n)
+	}
+	if n := fresh.Load(); n != 1 {
+		t.Errorf("%d callers got fresh, want 1", n)
+	}
+}
+
+// 一个很慢的密钥服务器不能挡住其他密钥的下载
+func TestKeyCacheSlowKeyDoesNotBlockOthers(t *testing.T) {
+	server := newKeyServer(t)
+	client := utils.NewClient(utils.DefaultClientOptions())
+	cache := newKeyCache()
+
+	slowDone := make(chan error, 1)
+	go func() {
+		_, _, err := cache.get(context.Background(), client, server.URL+"/slow", testPolicy)
+		slowDone <- err
+	}()
+	for server.count("/slow") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, _, err := cache.get(ctx, client, server.URL+"/fast", testPolicy); err != nil {
+		t.Fatalf("fast key blocked behind slow key: %v", err)
+	}
+
+	// 等待慢密钥的请求在自己的 ctx 取消后返回，不影响下载中的请求
+	waitCtx, waitCancel := context.WithCancel(context.Background())
+	waitCancel()
+	if _, _, err := cache.get(waitCtx, client, server.URL+"/slow", testPolicy); err == nil {
+		t.Error("waiter with a canceled context should return an error")
+	}
+
+	close(server.release)
+	if err := <-slowDone; err != nil {
+		t.Fatalf("slow key: %v", err)
+	}
+	if n := server.count("/slow"); n != 1 {
+		t.Errorf("slow key fetched %d times, want 1", n)
+	}
+}
+
+// 轮换密钥的直播流会不断出现新的密钥URI，缓存按最近最少使用淘汰，大小保持不变
+func TestKeyCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	server := newKeyServer(t)
+	client := utils.NewClient(utils.DefaultClientOptions())
+	cache := newKeyCache()
+	get := func(i int) {
+		t.Helper()
+		if _, _, err := cache.get(context.Background(), client, server.URL+"/key/"+strconv.Itoa(i), testPolicy); err != nil {
+			t.Fatalf("get key %d: %v", i, err)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		get(i)
+		get(0) // 一直在使用的密钥不能被淘汰
+		if len(cache.keys) > maxCachedKeys {
+			t.Fatalf("cache holds %d keys, want at most %d", len(cache.keys), maxCachedKeys)
+		}
+	}
+
+	if n := server.count("/key/0"); n != 1 {
+		t.Errorf("key in use fetched %d times, want 1", n)
+	}
+	get(1) // 早已淘汰，需要重新下载
+	if n := server.count("/key/1"); n != 2 {
+		t.Errorf("evicted key fetched %d times, want 2", n)
+	}
+}
+
+// SAMPLE-AES 只能解密 TS 片段，带 EXT-X-MAP 的 fMP4 片段直接报不支持，不下载密钥
+func TestResolveDecryptionRejectsSampleAESFMP4(t *testing.T) {
+	server := newKeyServer(t)
+	d := New()
+	track := &mediaTrack{name: "main", outputDir: t.TempDir()}
+	key := &parser.Key{Method: "SAMPLE-AES", URI: server.URL + "/key", KeyFormat: "identity"}
+
+	ts := parser.Segment{URI: "seg1.ts", MediaSequence: 1, Key: key}
+	decryption, err := d.resolveDecryption(context.Background(), track, ts)
+	if err != nil || decryption.Method != "SAMPLE-AES" || len(decryption.Key) != 16 {
+		t.Fatalf("TS segment: decryption %+v, err %v", decryption, err)
+	}
+
+	fmp4 := parser.Segment{URI: "seg2.m4s", MediaSequence: 2, Key: key, Map: &parser.Map{URI: "init.mp4"}}
+	key.URI = server.URL + "/other"
+	if _, err := d.resolveDecryption(context.Background(), track, fmp4); err == nil || !strings.Contains(err.Error(), "EXT-X-MAP") {
+		t.Errorf("fMP4 segment: err = %v, want unsupported SAMPLE-AES error", err)
+	}
+	if n := server.count("/other"); n != 0 {
+		t.Errorf("key for unsupported segment was fetched %d times", n)
+	}
+}
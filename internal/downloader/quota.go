@@ -0,0 +1,60 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"sync"
+
+	"github.com/MGter/hls_downloader/internal/storage"
+	"github.com/MGter/hls_downloader/pkg/logger"
+)
+
+// diskQuota 保存目录的配额。占用按开始时的目录大小加上之后保存的字节数估算，
+// 估算值超过配额时重新统计目录（期间可能有片段被清理），确认超过后才停止录制
+type diskQuota struct {
+	dir   string // 保存目录
+	limit int64  // 配额（字节）
+	stop  func() // 停止录制
+
+	mu       sync.Mutex // 多路媒体列表并发保存片段
+	used     int64      // 估算的占用
+	exceeded bool       // 是否已经超过配额，只通知一次
+}
+
+// newDiskQuota 统计保存目录当前的占用，创建配额
+func newDiskQuota(dir string, limit int64, stop func()) *diskQuota {
+	used, err := storage.DirSize(dir)
+	if err != nil {
+		logger.Warn.Printf("统计保存目录大小失败: %v，按 0 计算", err)
+	}
+	return &diskQuota{dir: dir, limit: limit, stop: stop, used: used}
+}
+
+// add 记录新保存的字节数，确认超过配额时返回当前占用
+func (q *diskQuota) add(n int64) (used int64, exceeded bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.used += n
+	if q.exceeded || q.used <= q.limit {
+		return q.used, false
+	}
+	if size, err := storage.DirSize(q.dir); err == nil {
+		q.used = size
+	}
+	q.exceeded = q.used > q.limit
+	return q.used, q.exceeded
+}
+
+// checkQuota 记录本批保存的字节数，超过配额时通知并停止录制
+func (d *HLSDownloader) checkQuota(saved int64) {
+	if d.quota == nil || saved <= 0 {
+		return
+	}
+	used, exceeded := d.quota.add(saved)
+	if !exceeded {
+		return
+	}
+
+	logger.Error.Printf("保存目录已占用 %d 字节，超过配额 %d 字节，停止录制", used, d.quota.limit)
+	d.observer().DiskQuotaExceeded(QuotaEvent{OutputDir: d.quota.dir, Limit: d.quota.limit, Used: used})
+	d.quota.stop()
+}
@@ -0,0 +1,85 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/MGter/hls_downloader/internal/parser"
+	"github.com/MGter/hls_downloader/pkg/logger"
+)
+
+// reloadState 播放列表的刷新状态，按 RFC 8216 6.3.4 计算下次刷新时间
+type reloadState struct {
+	loadedAt       time.Time     // 最近一次成功获取列表的时间
+	targetDuration time.Duration // EXT-X-TARGETDURATION
+	lastDuration   time.Duration // 列表中最后一个片段的时长
+	signature      string        // 用于判断列表是否变化的摘要
+	changed        bool          // 最近一次刷新时列表是否有变化
+	lastChange     time.Time     // 列表最近一次变化的时间
+	staleWarned    bool          // 本轮停滞是否已经告警，避免重复输出
+}
+
+// update 记录新获取的列表，判断是否发生变化；列表刚进入停滞状态时返回停滞了多久
+func (r *reloadState) update(track *mediaTrack, playlist *parser.Playlist, now time.Time) (stalled time.Duration) {
+	r.loadedAt = now
+	r.targetDuration = time.Duration(playlist.TargetDuration) * time.Second
+	r.lastDuration = 0
+	signature := fmt.Sprintf("%d/%d", playlist.MediaSequence, len(playlist.Segments))
+	if n := len(playlist.Segments); n > 0 {
+		last := playlist.Segments[n-1]
+		r.lastDuration = time.Duration(last.Duration * float64(time.Second))
+		signature += "/" + last.URI
+	}
+
+	r.changed = signature != r.signature
+	r.signature = signature
+	if r.changed || r.lastChange.IsZero() {
+		if r.staleWarned {
+			log.Printf("[%s] 播放列表恢复更新，停滞了 %v", track.name, now.Sub(r.lastChange).Round(time.Second))
+		}
+		r.lastChange = now
+		r.staleWarned = false
+		return 0
+	}
+
+	// 列表超过1.5倍目标时长没有变化，说明服务器在返回过期的列表
+	if stale := now.Sub(r.lastChange); r.targetDuration > 0 && stale > r.targetDuration*3/2 && !r.staleWarned && !playlist.EndList {
+		logger.Warn.Printf("[%s] 播放列表已 %v 没有更新（目标时长 %v），服务器可能返回了过期列表",
+			track.name, stale.Round(time.Second), r.targetDuration)
+		r.staleWarned = true
+		return stale
+	}
+	return 0
+}
+
+// reloadDelay 计算距离下次刷新还需等待的时间：
+// 列表有变化时等待最后一个片段的时长，没有变化时等待目标时长的一半，
+// 从获取列表的时刻起算，并限制在配置的上下限之间
+func (d *HLSDownloader) reloadDelay(track *mediaTrack) time.Duration {
+	r := &track.reload
+	if r.targetDuration <= 0 || r.loadedAt.IsZero() {
+		return d.config.DownloadInterval // 没有目标时长信息时使用固定间隔
+	}
+
+	interval := r.targetDuration / 2
+	if r.changed {
+		interval = r.lastDuration
+		if interval <= 0 {
+			interval = r.targetDuration
+		}
+	}
+
+	if d.config.MinReloadInterval > 0 && interval < d.config.MinReloadInterval {
+		interval = d.config.MinReloadInterval
+	}
+	if d.config.MaxReloadInterval > 0 && interval > d.config.MaxReloadInterval {
+		interval = d.config.MaxReloadInterval
+	}
+
+	// 扣除下载片段已经花费的时间
+	if wait := interval - time.Since(r.loadedAt); wait > 0 {
+		return wait
+	}
+	return 0
+}
@@ -0,0 +1,167 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/MGter/hls_downloader/internal/parser"
+	"github.com/MGter/hls_downloader/internal/storage"
+	"github.com/MGter/hls_downloader/pkg/logger"
+)
+
+// RenditionFilter 备用渲染（EXT-X-MEDIA）的录制范围
+type RenditionFilter struct {
+	Audio       bool     // 是否录制所选版本引用的 AUDIO 组
+	Subtitles   bool     // 是否录制所选版本引用的 SUBTITLES 组
+	Languages   []string // 只录制这些语言，前缀匹配（"en" 匹配 "en-US"），空表示不限制
+	Names       []string // 只录制这些 NAME，空表示不限制
+	DefaultOnly bool     // 只录制 DEFAULT=YES 的渲染
+}
+
+// Matches 判断渲染是否在录制范围内
+func (f RenditionFilter) Matches(r parser.Rendition) bool {
+	switch r.Type {
+	case "AUDIO":
+		if !f.Audio {
+			return false
+		}
+	case "SUBTITLES":
+		if !f.Subtitles {
+			return false
+		}
+	default:
+		return false // VIDEO 和 CLOSED-CAPTIONS 不单独录制
+	}
+
+	if f.DefaultOnly && !r.Default {
+		return false
+	}
+	if len(f.Languages) > 0 && !matchAny(f.Languages, func(lang string) bool {
+		return strings.HasPrefix(strings.ToLower(r.Language), strings.ToLower(lang))
+	}) {
+		return false
+	}
+	if len(f.Names) > 0 && !matchAny(f.Names, func(name string) bool {
+		return strings.EqualFold(r.Name, name)
+	}) {
+		return false
+	}
+	return true
+}
+
+// resolveTracksWithRetry 解析入口播放列表，失败时按下载间隔重试
+func (d *HLSDownloader) resolveTracksWithRetry(ctx context.Context, m3u8URL, outputDir string) ([]*mediaTrack, error) {
+	for {
+		tracks, err := d.resolveTracks(ctx, m3u8URL, outputDir)
+		if err == nil {
+			return tracks, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logger.Warn.Printf("解析入口播放列表失败: %v，将在 %v 后重试", err, d.config.DownloadInterval)
+		sleepContext(ctx, d.config.DownloadInterval)
+	}
+}
+
+// resolveTracks 解析入口播放列表：媒体列表直接录制，主播放列表按策略选出码率版本及其渲染
+func (d *HLSDownloader) resolveTracks(ctx context.Context, m3u8URL, outputDir string) ([]*mediaTrack, error) {
+	playlist, err := d.fetchPlaylist(ctx, m3u8URL)
+	if err != nil {
+		return nil, err
+	}
+
+	// 入口本身就是媒体列表
+	if !playlist.IsMaster {
+		return []*mediaTrack{newMediaTrack("main", m3u8URL, outputDir)}, nil
+	}
+
+	// 检查是否有媒体播放列表
+	if len(playlist.Variants) == 0 {
+		return nil, fmt.Errorf("主播放列表中未找到媒体列表")
+	}
+	// 按配置的策略选择码率版本
+	selected, err := d.config.VariantSelection.SelectVariant(playlist.Variants)
+	if err != nil {
+		return nil, fmt.Errorf("选择码率版本失败: %w", err)
+	}
+	log.Printf("发现主播放列表（%d 个码率版本），按 %s 策略切换到媒体列表: %s [码率 %d, 分辨率 %dx%d, 编码 %s]",
+		len(playlist.Variants), d.config.VariantSelection.Policy, selected.URI, selected.Bandwidth,
+		selected.Width, selected.Height, strings.Join(selected.Codecs, ","))
+	d.observer().VariantSwitched(VariantEvent{
+		MasterURL: m3u8URL,
+		URI:       selected.URI,
+		Policy:    d.config.VariantSelection.Policy,
+		Variants:  len(playlist.Variants),
+		Bandwidth: selected.Bandwidth,
+		Width:     selected.Width,
+		Height:    selected.Height,
+		Codecs:    selected.Codecs,
+	})
+
+	tracks := []*mediaTrack{newMediaTrack("main", selected.URI, outputDir)}
+	used := map[string]bool{"main": true}
+
+	// 跟随所选版本引用的音频和字幕组
+	for _, r := range playlist.Renditions {
+		if !referencedBy(selected, r) || !d.config.Renditions.Matches(r) {
+			continue
+		}
+		if r.URI == "" {
+			continue // 没有URI的渲染已混合在主码流中
+		}
+
+		name := uniqueName(used, renditionName(r), r.Language)
+		tracks = append(tracks, newMediaTrack(name, r.URI, path.Join(outputDir, name)))
+		log.Printf("录制备用渲染 %s: %s [语言 %s, 名称 %s]", name, r.URI, r.Language, r.Name)
+	}
+
+	return tracks, nil
+}
+
+// referencedBy 判断渲染组是否被码率版本引用
+func referencedBy(v parser.Variant, r parser.Rendition) bool {
+	switch r.Type {
+	case "AUDIO":
+		return v.Audio != "" && v.Audio == r.GroupID
+	case "SUBTITLES":
+		return v.Subtitles != "" && v.Subtitles == r.GroupID
+	}
+	return false
+}
+
+// renditionName 生成渲染的名称，同时用作子目录名，例如 audio_aac_English
+func renditionName(r parser.Rendition) string {
+	label := r.Name
+	if label == "" {
+		label = r.Language
+	}
+	return storage.SanitizeName(fmt.Sprintf("%s_%s_%s", strings.ToLower(r.Type), r.GroupID, label))
+}
+
+// uniqueName 避免两路渲染使用同一个子目录和续录状态：名称已被使用时依次尝试加上语言和序号后缀。
+// 比较时忽略大小写，不区分大小写的文件系统上这些目录也是同一个
+func uniqueName(used map[string]bool, name, language string) string {
+	candidate := name
+	if used[strings.ToLower(candidate)] && language != "" {
+		candidate = storage.SanitizeName(name + "_" + language)
+	}
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s_%d", name, i)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// matchAny 判断列表中是否有元素满足条件
+func matchAny(values []string, match func(string) bool) bool {
+	for _, v := range values {
+		if match(v) {
+			return true
+		}
+	}
+	return false
+}
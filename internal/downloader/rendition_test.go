@@ -0,0 +1,60 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// 同一组内 NAME 相同、或只在被替换的字符上不同的渲染，必须落到不同的子目录
+func TestResolveTracksUniqueRenditionDirs(t *testing.T) {
+	master := `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Main",LANGUAGE="en",URI="en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Main",LANGUAGE="de",URI="de.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Main",LANGUAGE="de",URI="de2.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Dolby 5.1",URI="a.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Dolby 5/1",URI="b.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="dolby 5.1",URI="c.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1000000,AUDIO="aac"
+video.m3u8
+`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, master)
+	}))
+	defer server.Close()
+
+	outputDir := t.TempDir()
+	tracks, err := New().resolveTracks(context.Background(), server.URL+"/master.m3u8", outputDir)
+	if err != nil {
+		t.Fatalf("resolveTracks: %v", err)
+	}
+
+	want := []string{
+		"main",
+		"audio_aac_Main",
+		"audio_aac_Main_de",
+		"audio_aac_Main_2",
+		"audio_aac_Dolby_5_1",
+		"audio_aac_Dolby_5_1_2",
+		"audio_aac_dolby_5_1_3",
+	}
+	if len(tracks) != len(want) {
+		t.Fatalf("got %d tracks, want %d", len(tracks), len(want))
+	}
+	dirs := make(map[string]string)
+	for i, track := range tracks {
+		if track.name != want[i] {
+			t.Errorf("track %d: name %q, want %q", i, track.name, want[i])
+		}
+		if other, ok := dirs[track.outputDir]; ok {
+			t.Errorf("tracks %q and %q share output dir %s", other, track.name, track.outputDir)
+		}
+		dirs[track.outputDir] = track.name
+		if i > 0 && track.outputDir != filepath.Join(outputDir, track.name) {
+			t.Errorf("track %q: output dir %s", track.name, track.outputDir)
+		}
+	}
+}
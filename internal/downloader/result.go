@@ -0,0 +1,62 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"maps"
+
+	"github.com/MGter/hls_downloader/internal/storage"
+)
+
+// Result 一次录制的结果，与退出时输出的汇总一致
+type Result struct {
+	OutputDir string        // 保存目录
+	Ended     bool          // 所有媒体列表都已结束（ENDLIST / VOD）；false 表示录制被停止
+	Tracks    []TrackResult // 每路媒体列表的统计，还没解析出媒体列表就停止时为空
+}
+
+// TrackResult 一路媒体列表的录制统计
+type TrackResult struct {
+	Name            string                     // 媒体列表名称
+	PlaylistURL     string                     // 媒体播放列表地址
+	OutputDir       string                     // 片段保存目录
+	Ended           bool                       // 列表是否已经结束
+	Queued          int                        // 提交下载的片段数，重试的片段会重复计数
+	Bytes           int64                      // 已保存的字节数
+	FailedBatches   int                        // 有片段下载失败的批次数
+	Failures        int                        // 片段重试用尽后失败的次数
+	FailuresByClass map[storage.ErrorClass]int // 按失败分类统计的失败次数
+	LostCount       int                        // 永久丢失的片段数
+	Lost            []LostSegment              // 丢失的片段，最多列出 1000 个
+}
+
+// LostSegment 没有下载成功的片段
+type LostSegment struct {
+	Sequence int    // 媒体序列号
+	URI      string // 片段地址
+	Attempts int    // 下载过的轮数
+	Err      error  // 最后一次失败的原因
+}
+
+// result 汇总各路媒体列表的统计，需要在 logSummary 之后调用
+func (d *HLSDownloader) result(outputDir string, tracks []*mediaTrack) *Result {
+	result := &Result{OutputDir: outputDir, Ended: len(tracks) > 0}
+	for _, track := range tracks {
+		tr := TrackResult{
+			Name:            track.name,
+			PlaylistURL:     track.playlistURL,
+			OutputDir:       track.outputDir,
+			Ended:           track.ended,
+			Queued:          track.stats.queued,
+			Bytes:           track.stats.bytes,
+			FailedBatches:   track.stats.failedBatches,
+			Failures:        track.stats.failures,
+			FailuresByClass: maps.Clone(track.stats.byClass),
+			LostCount:       track.stats.lost,
+		}
+		for _, lost := range track.lost {
+			tr.Lost = append(tr.Lost, LostSegment{Sequence: lost.seq, URI: lost.uri, Attempts: lost.attempts, Err: lost.lastErr})
+		}
+		result.Ended = result.Ended && track.ended
+		result.Tracks = append(result.Tracks, tr)
+	}
+	return result
+}
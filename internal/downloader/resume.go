@@ -0,0 +1,70 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/MGter/hls_downloader/internal/storage"
+	"github.com/MGter/hls_downloader/pkg/logger"
+)
+
+// loadResumeState 读取保存目录中的续录状态，把记录过的片段标记为已下载
+func (d *HLSDownloader) loadResumeState(track *mediaTrack) error {
+	state, err := storage.LoadState(track.outputDir)
+	if err != nil {
+		return fmt.Errorf("加载续录状态失败: %w", err)
+	}
+
+	if state.PlaylistURL != "" && state.PlaylistURL != track.playlistURL {
+		log.Printf("[%s] 续录状态来自另一个媒体列表 %s，仍按片段ID去重", track.name, state.PlaylistURL)
+	}
+	state.PlaylistURL = track.playlistURL
+
+	for _, record := range state.Segments {
+		track.window.markDone(record.ID, record.MediaSequence, record.URI)
+	}
+	track.window.restoreFloor()
+	if len(state.Segments) > 0 {
+		log.Printf("[%s] 从状态文件恢复 %d 个已下载片段，继续上次的录制", track.name, len(state.Segments))
+	}
+
+	track.state = state
+	return nil
+}
+
+// saveResumeState 把本批下载成功的片段追加到续录状态并写入磁盘，
+// 已经滑出去重窗口的记录同时丢弃，状态文件的大小和窗口保持一致
+func (d *HLSDownloader) saveResumeState(track *mediaTrack, ids []string, results []storage.DownloadResult) {
+	track.state.Segments = slices.DeleteFunc(track.state.Segments, func(record storage.SegmentRecord) bool {
+		return !track.window.done(record.ID)
+	})
+
+	added := 0
+	for i, result := range results {
+		if result.Err != nil || result.Filename == "" {
+			continue
+		}
+		track.state.Segments = append(track.state.Segments, storage.SegmentRecord{
+			ID:            ids[i],
+			MediaSequence: result.Task.Sequence,
+			URI:           result.Task.URL,
+			Size:          result.Size,
+			Checksum:      result.Checksum,
+			File:          path.Base(result.Filename),
+			DownloadedAt:  time.Now(),
+		})
+		track.stats.bytes += result.Size
+		added++
+	}
+	if added == 0 {
+		return
+	}
+
+	// 状态写入失败不影响录制，下次启动最多重复下载这一批
+	if err := storage.SaveState(track.outputDir, track.state); err != nil {
+		logger.Warn.Printf("[%s] 保存续录状态失败: %v", track.name, err)
+	}
+}
@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/MGter/hls_downloader/internal/parser"
+	"github.com/MGter/hls_downloader/internal/storage"
+)
+
+// playlistSegments 生成从 first 开始的 n 个连续片段
+func playlistSegments(first, n int) []parser.Segment {
+	segments := make([]parser.Segment, n)
+	for i := range segments {
+		seq := first + i
+		segments[i] = parser.Segment{URI: "https://example.com/seg" + strconv.Itoa(seq) + ".ts", Duration: 6, MediaSequence: seq}
+	}
+	return segments
+}
+
+// resumedTrack 创建一路媒体列表，并从保存了 segments 的状态文件恢复
+func resumedTrack(t *testing.T, segments []parser.Segment) *mediaTrack {
+	t.Helper()
+	dir := t.TempDir()
+	state := &storage.ResumeState{PlaylistURL: "https://example.com/live.m3u8"}
+	for _, s := range segments {
+		state.Segments = append(state.Segments, storage.SegmentRecord{ID: s.ID(), MediaSequence: s.MediaSequence, URI: s.URI})
+	}
+	if err := storage.SaveState(dir, state); err != nil {
+		t.Fatal(err)
+	}
+
+	track := newMediaTrack("main", "https://example.com/live.m3u8", dir)
+	if err := New().loadResumeState(track); err != nil {
+		t.Fatalf("loadResumeState: %v", err)
+	}
+	return track
+}
+
+func TestResumeSkipsRecordedSegments(t *testing.T) {
+	track := resumedTrack(t, playlistSegments(100, 6))
+
+	newSegments, _ := New().filterNewSegments(track, playlistSegments(103, 6), 103)
+	if len(newSegments) != 3 || newSegments[0].MediaSequence != 106 {
+		t.Fatalf("got %d new segments starting at %v, want 106..108", len(newSegments), newSegments)
+	}
+}
+
+// 重启前录制到 #100~#105，推流重启后序列号从0开始：第一次刷新就要清空旧记录，
+// 之后新流走到 #100 时 ID 相同的片段不能被当作已下载
+func TestResumeAfterSequenceReset(t *testing.T) {
+	d := New()
+	track := resumedTrack(t, playlistSegments(100, 6))
+
+	if newSegments, _ := d.filterNewSegments(track, playlistSegments(0, 6), 0); len(newSegments) != 6 {
+		t.Fatalf("after reset: got %d new segments, want 6", len(newSegments))
+	}
+	if track.window.len() != 6 {
+		t.Fatalf("stale records kept after reset: window has %d entries, want 6", track.window.len())
+	}
+	for _, id := range []string{"0:100", "0:105"} {
+		if track.window.done(id) {
+			t.Errorf("stale record %s still marked done", id)
+		}
+	}
+
+	if newSegments, _ := d.filterNewSegments(track, playlistSegments(100, 6), 100); len(newSegments) != 6 {
+		t.Fatalf("new stream at #100: got %d new segments, want 6", len(newSegments))
+	}
+}
@@ -0,0 +1,26 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SegmentIdentity 判断片段是否已下载时使用的标识策略
+type SegmentIdentity string
+
+const (
+	IdentitySequence SegmentIdentity = "sequence" // 不连续序列号 + 媒体序列号（默认）
+	IdentityFilename SegmentIdentity = "filename" // 文件名末尾的数字（旧行为），用于媒体序列号不可靠的源
+)
+
+// ParseSegmentIdentity 将字符串解析为片段标识策略
+func ParseSegmentIdentity(name string) (SegmentIdentity, error) {
+	identity := SegmentIdentity(strings.ToLower(strings.TrimSpace(name)))
+	switch identity {
+	case IdentitySequence, IdentityFilename:
+		return identity, nil
+	case "":
+		return IdentitySequence, nil
+	}
+	return "", fmt.Errorf("未知的片段标识策略: %s", name)
+}
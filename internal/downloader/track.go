@@ -0,0 +1,81 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/MGter/hls_downloader/internal/storage"
+)
+
+// maxLostReport 汇总中最多列出的丢失片段数，超出部分只计数
+const maxLostReport = 1000
+
+// mediaTrack 一路正在录制的媒体播放列表（主码流或某个音频/字幕渲染）
+type mediaTrack struct {
+	name        string               // 日志中显示的名称
+	playlistURL string               // 媒体播放列表地址
+	outputDir   string               // 片段保存目录
+	window      *segmentWindow       // 按媒体序列号滑动的已下载记录，避免重复下载
+	init        initSegmentState     // 当前的 EXT-X-MAP 初始化片段
+	reload      reloadState          // 播放列表刷新状态
+	stats       trackStats           // 录制统计，退出时输出汇总
+	state       *storage.ResumeState // 持久化的续录状态
+	lost        []lostSegment        // 没有下载成功就滑出列表的片段，退出时列出
+	ended       bool                 // 播放列表已经结束（ENDLIST / VOD）
+}
+
+// trackStats 一路媒体列表的录制统计
+type trackStats struct {
+	queued        int   // 已提交下载的片段数
+	failedBatches int   // 有片段下载失败的批次数
+	bytes         int64 // 已保存的字节数
+	lost          int   // 永久丢失的片段数
+	failures      int   // 片段重试用尽后失败的次数（同一片段重试失败会重复计数）
+	byClass       map[storage.ErrorClass]int // 按失败分类统计的失败次数
+}
+
+// recordFailure 记录一次片段失败
+func (s *trackStats) recordFailure(class storage.ErrorClass) {
+	if s.byClass == nil {
+		s.byClass = make(map[storage.ErrorClass]int)
+	}
+	s.failures++
+	s.byClass[class]++
+}
+
+// failureSummary 把失败分类统计格式化为 "http_4xx: 2, timeout: 1"
+func (s *trackStats) failureSummary() string {
+	classes := make([]string, 0, len(s.byClass))
+	for class := range s.byClass {
+		classes = append(classes, string(class))
+	}
+	slices.Sort(classes)
+
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = fmt.Sprintf("%s: %d", class, s.byClass[storage.ErrorClass(class)])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// newMediaTrack 创建一路媒体列表
+func newMediaTrack(name, playlistURL, outputDir string) *mediaTrack {
+	return &mediaTrack{
+		name:        name,
+		playlistURL: playlistURL,
+		outputDir:   outputDir,
+		window:      newSegmentWindow(), // 初始化已下载记录（空窗口）
+	}
+}
+
+// recordLost 记录永久丢失的片段，按序列号排列，退出时在汇总中列出
+func (t *mediaTrack) recordLost(lost []lostSegment) {
+	slices.SortFunc(lost, func(a, b lostSegment) int { return a.seq - b.seq })
+	for _, l := range lost {
+		t.stats.lost++
+		if len(t.lost) < maxLostReport {
+			t.lost = append(t.lost, l)
+		}
+	}
+}
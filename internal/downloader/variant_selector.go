@@ -0,0 +1,174 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MGter/hls_downloader/internal/parser"
+)
+
+// VariantPolicy 主播放列表的码率版本选择策略
+type VariantPolicy string
+
+const (
+	VariantHighest    VariantPolicy = "highest"     // 码率最高的版本
+	VariantLowest     VariantPolicy = "lowest"      // 码率最低的版本
+	VariantResolution VariantPolicy = "resolution"  // 分辨率最接近目标的版本
+	VariantMaxBitrate VariantPolicy = "max-bitrate" // 不超过码率上限的最高码率版本
+	VariantIndex      VariantPolicy = "index"       // 按主播放列表中的顺序指定
+	VariantFirst      VariantPolicy = "first"       // 列表中的第一个版本（旧行为）
+)
+
+// VariantSelection 码率版本选择配置
+type VariantSelection struct {
+	Policy          VariantPolicy // 选择策略
+	TargetWidth     int           // resolution 策略的目标宽度
+	TargetHeight    int           // resolution 策略的目标高度
+	MaxBandwidth    int           // 码率上限（bit/s），0 表示不限制；对所有策略生效
+	CodecPreference []string      // 编码偏好，按优先级排列，例如 ["avc1", "hevc"]
+	Index           int           // index 策略使用的版本序号（从0开始）
+}
+
+// codecAliases 常见编码名称到 CODECS 前缀的映射
+var codecAliases = map[string][]string{
+	"h264": {"avc1", "avc3"},
+	"avc":  {"avc1", "avc3"},
+	"h265": {"hvc1", "hev1"},
+	"hevc": {"hvc1", "hev1"},
+	"av1":  {"av01"},
+	"vp9":  {"vp09"},
+}
+
+// ParseVariantPolicy 将字符串解析为选择策略
+func ParseVariantPolicy(name string) (VariantPolicy, error) {
+	policy := VariantPolicy(strings.ToLower(strings.TrimSpace(name)))
+	switch policy {
+	case VariantHighest, VariantLowest, VariantResolution, VariantMaxBitrate, VariantIndex, VariantFirst:
+		return policy, nil
+	case "":
+		return VariantHighest, nil
+	}
+	return "", fmt.Errorf("未知的码率选择策略: %s", name)
+}
+
+// SelectVariant 按配置从主播放列表中选出一个码率版本
+func (s VariantSelection) SelectVariant(variants []parser.Variant) (parser.Variant, error) {
+	if len(variants) == 0 {
+		return parser.Variant{}, fmt.Errorf("没有可选的码率版本")
+	}
+
+	// 显式序号不经过任何过滤
+	if s.Policy == VariantIndex {
+		if s.Index < 0 || s.Index >= len(variants) {
+			return parser.Variant{}, fmt.Errorf("码率版本序号 %d 超出范围 [0, %d)", s.Index, len(variants))
+		}
+		return variants[s.Index], nil
+	}
+	if s.Policy == VariantFirst {
+		return variants[0], nil
+	}
+
+	// 先按编码偏好和码率上限缩小候选范围
+	candidates := s.filterByCodec(variants)
+	candidates = s.filterByBandwidth(candidates)
+
+	switch s.Policy {
+	case VariantLowest:
+		return pickBy(candidates, func(a, b parser.Variant) bool { return a.Bandwidth < b.Bandwidth }), nil
+	case VariantResolution:
+		return s.closestResolution(candidates), nil
+	case VariantMaxBitrate:
+		if s.MaxBandwidth <= 0 {
+			return parser.Variant{}, fmt.Errorf("max-bitrate 策略需要设置码率上限")
+		}
+		return pickBy(candidates, func(a, b parser.Variant) bool { return a.Bandwidth > b.Bandwidth }), nil
+	default:
+		return pickBy(candidates, func(a, b parser.Variant) bool { return a.Bandwidth > b.Bandwidth }), nil
+	}
+}
+
+// filterByCodec 返回符合最高优先级编码偏好的版本，没有任何匹配时返回全部
+func (s VariantSelection) filterByCodec(variants []parser.Variant) []parser.Variant {
+	for _, preferred := range s.CodecPreference {
+		prefixes := codecAliases[strings.ToLower(preferred)]
+		if prefixes == nil {
+			prefixes = []string{strings.ToLower(preferred)}
+		}
+
+		var matched []parser.Variant
+		for _, v := range variants {
+			if hasCodec(v, prefixes) {
+				matched = append(matched, v)
+			}
+		}
+		if len(matched) > 0 {
+			return matched
+		}
+	}
+	return variants
+}
+
+// filterByBandwidth 去掉超过码率上限的版本，全部超出时只保留码率最低的一个
+func (s VariantSelection) filterByBandwidth(variants []parser.Variant) []parser.Variant {
+	if s.MaxBandwidth <= 0 {
+		return variants
+	}
+
+	var matched []parser.Variant
+	for _, v := range variants {
+		if v.Bandwidth <= s.MaxBandwidth {
+			matched = append(matched, v)
+		}
+	}
+	if len(matched) == 0 {
+		lowest := pickBy(variants, func(a, b parser.Variant) bool { return a.Bandwidth < b.Bandwidth })
+		return []parser.Variant{lowest}
+	}
+	return matched
+}
+
+// closestResolution 选出像素数最接近目标分辨率的版本，相同时取码率高的
+func (s VariantSelection) closestResolution(variants []parser.Variant) parser.Variant {
+	target := s.TargetWidth * s.TargetHeight
+	distance := func(v parser.Variant) int {
+		if v.Width == 0 || v.Height == 0 {
+			return int(^uint(0) >> 1) // 没有分辨率信息（如纯音频）的排在最后
+		}
+		d := v.Width*v.Height - target
+		if d < 0 {
+			d = -d
+		}
+		return d
+	}
+
+	return pickBy(variants, func(a, b parser.Variant) bool {
+		da, db := distance(a), distance(b)
+		if da != db {
+			return da < db
+		}
+		return a.Bandwidth > b.Bandwidth
+	})
+}
+
+// hasCodec 判断版本的 CODECS 中是否有以指定前缀开头的编码
+func hasCodec(v parser.Variant, prefixes []string) bool {
+	for _, codec := range v.Codecs {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(strings.ToLower(codec), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pickBy 返回按 better 比较最优的版本，相同时保留列表中靠前的
+func pickBy(variants []parser.Variant, better func(a, b parser.Variant) bool) parser.Variant {
+	best := variants[0]
+	for _, v := range variants[1:] {
+		if better(v, best) {
+			best = v
+		}
+	}
+	return best
+}
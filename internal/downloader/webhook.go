@@ -0,0 +1,376 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MGter/hls_downloader/pkg/logger"
+	"github.com/MGter/hls_downloader/pkg/utils"
+)
+
+// DefaultWebhookEvents 没有指定事件时发送的事件：录制开始、流结束、停滞、连续失败和超过磁盘配额
+var DefaultWebhookEvents = []string{
+	EventRecordingStarted, EventStreamEnded, EventStalled, EventRepeatedFailure, EventDiskQuota,
+}
+
+// webhookDrainTimeout Close 时等待队列中的事件发送完的最长时间，超时后放弃剩余的事件
+const webhookDrainTimeout = 30 * time.Second
+
+// WebhookOptions HTTP 回调的设置。设置了 Secret 时，X-HLS-Timestamp 头为发送时的 Unix 秒数，
+// 签名同时覆盖时间戳和请求体，接收方应拒绝时间相差过大的请求，防止截获的请求被重放
+type WebhookOptions struct {
+	URL         string        // 接收事件的地址，每个事件以 JSON POST 一次
+	Secret      string        // 签名密钥，非空时 X-HLS-Signature 头为 "sha256=" + HMAC-SHA256(X-HLS-Timestamp + "." + 请求体) 的十六进制
+	Events      []string      // 需要发送的事件，空表示 DefaultWebhookEvents
+	Timeout     time.Duration // 单次请求的超时
+	MaxAttempts int           // 每个事件最多尝试的次数（包括第一次）
+	RetryDelay  time.Duration // 第一次重试前的退避上限，之后每次翻倍并加随机抖动
+	QueueSize   int           // 等待发送的事件数上限，队列满时丢弃新事件，不阻塞录制
+}
+
+// DefaultWebhookOptions 返回默认的回调设置，URL 需要另外指定
+func DefaultWebhookOptions() WebhookOptions {
+	return WebhookOptions{
+		Timeout:     10 * time.Second, // 单次请求最多10秒
+		MaxAttempts: 5,                // 最多尝试5次
+		RetryDelay:  time.Second,      // 第一次重试前最多等待1秒
+		QueueSize:   1024,             // 最多积压1024个事件
+	}
+}
+
+// webhookPayload POST 的 JSON 内容
+type webhookPayload struct {
+	ID    string         `json:"id"`              // 事件ID，重试时不变，接收方可以据此去重
+	Event string         `json:"event"`           // 事件名称
+	Time  time.Time      `json:"time"`            // 事件发生的时间
+	Track string         `json:"track,omitempty"` // 媒体列表名称，与具体媒体列表无关的事件为空
+	Data  map[string]any `json:"data"`            // 事件详情
+}
+
+// WebhookObserver 把事件以 JSON POST 到指定地址。事件先放入队列，由后台协程逐个发送，
+// 失败时按指数退避重试，不会阻塞录制；录制结束后调用 Close 把队列中的事件发送完
+type WebhookObserver struct {
+	options WebhookOptions
+	events  map[string]bool   // 需要发送的事件
+	client  *http.Client      // 发送回调使用的HTTP客户端，与录制的客户端分开
+	policy  utils.RetryPolicy // 重试策略
+
+	ctx    context.Context    // Close 超时后取消，放弃进行中的重试
+	cancel context.CancelFunc // 取消 ctx
+
+	mu      sync.Mutex          // 保护 started、closed 和 dropped，保证 Close 之后不再入队
+	started bool                // 发送协程是否已经启动，第一个事件到来时才启动
+	closed  bool                // 是否已经关闭
+	dropped int                 // 队列满时丢弃的事件数
+	queue   chan webhookPayload // 等待发送的事件
+	done    chan struct{}       // 发送协程退出后关闭
+}
+
+// Validate 检查回调设置是否有效，不创建任何资源
+func (o WebhookOptions) Validate() error {
+	target, err := url.Parse(o.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("无效的 Webhook 地址 %q，需要 http:// 或 https:// 地址", o.URL)
+	}
+	if o.MaxAttempts < 1 {
+		return fmt.Errorf("Webhook 最大尝试次数必须至少为1，当前为 %d", o.MaxAttempts)
+	}
+	if o.QueueSize < 1 {
+		return fmt.Errorf("Webhook 队列长度必须至少为1，当前为 %d", o.QueueSize)
+	}
+	_, err = checkEventNames(o.events())
+	return err
+}
+
+// events 返回需要发送的事件，未指定时使用默认事件
+func (o WebhookOptions) events() []string {
+	if len(o.Events) == 0 {
+		return DefaultWebhookEvents
+	}
+	return o.Events
+}
+
+// NewWebhookObserver 检查设置并创建回调
+func NewWebhookObserver(options WebhookOptions) (*WebhookObserver, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	filter, err := checkEventNames(options.events())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookObserver{
+		options: options,
+		events:  filter,
+		client:  &http.Client{Timeout: options.Timeout},
+		policy:  utils.RetryPolicy{MaxAttempts: options.MaxAttempts, BaseDelay: options.RetryDelay, MaxDelay: time.Minute},
+		ctx:     ctx,
+		cancel:  cancel,
+		queue:   make(chan webhookPayload, options.QueueSize),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Close 停止接收事件，等待队列中的事件发送完，最多等待 30 秒
+func (w *WebhookObserver) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	started, dropped := w.started, w.dropped
+	close(w.queue)
+	w.mu.Unlock()
+
+	defer w.cancel()
+	if dropped > 0 {
+		logger.Warn.Printf("Webhook 队列已满，共丢弃了 %d 个事件", dropped)
+	}
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-w.done:
+		return nil
+	case <-time.After(webhookDrainTimeout):
+	}
+	w.cancel()
+	<-w.done
+	return fmt.Errorf("等待 Webhook 发送超时，放弃了队列中剩余的事件")
+}
+
+// enqueue 把事件放入发送队列，没有订阅该事件、已经关闭或队列已满时丢弃
+func (w *WebhookObserver) enqueue(event, track string, data map[string]any) {
+	if !w.events[event] {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if !w.started {
+		w.started = true
+		go w.run()
+	}
+
+	payload := webhookPayload{ID: newEventID(), Event: event, Time: time.Now(), Track: track, Data: data}
+	select {
+	case w.queue <- payload:
+	default:
+		// 接收方长时间不可用时宁可丢弃事件，也不能拖慢录制
+		if w.dropped == 0 {
+			logger.Warn.Printf("Webhook 队列已满（%d 个），开始丢弃新事件", cap(w.queue))
+		}
+		w.dropped++
+	}
+}
+
+// newEventID 生成随机的事件ID
+func newEventID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// run 按顺序发送队列中的事件，直到队列关闭
+func (w *WebhookObserver) run() {
+	defer close(w.done)
+	for payload := range w.queue {
+		if w.ctx.Err() != nil {
+			continue // Close 已经超时，丢弃剩余的事件
+		}
+		if err := w.deliver(payload); err != nil {
+			logger.Warn.Printf("Webhook 事件 %s 发送失败: %v", payload.Event, err)
+		}
+	}
+}
+
+// deliver 发送一个事件，失败时按重试策略重试
+func (w *WebhookObserver) deliver(payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("序列化事件失败: %w", err)
+	}
+
+	return w.policy.Do(w.ctx, func(attempt int) error {
+		req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.options.URL, bytes.NewReader(body))
+		if err != nil {
+			return &utils.PermanentError{Err: err}
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "hls_downloader")
+		req.Header.Set("X-HLS-Event", payload.Event)
+		req.Header.Set("X-HLS-Delivery", payload.ID)
+		req.Header.Set("X-HLS-Attempt", strconv.Itoa(attempt))
+		if w.options.Secret != "" {
+			// 签名包含发送时间，截获的请求过期后不能重放；每次重试都重新签名
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set("X-HLS-Timestamp", timestamp)
+			req.Header.Set("X-HLS-Signature", "sha256="+signPayload(w.options.Secret, timestamp, body))
+		}
+
+		resp, err := w.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // 读完响应体以复用连接
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return utils.NewHTTPStatusError(resp)
+		}
+		return nil
+	})
+}
+
+// signPayload 计算 timestamp + "." + 请求体的 HMAC-SHA256 签名（十六进制）
+func signPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// errorText 把错误转换为 JSON 中的字符串，nil 时为空
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// segmentData 片段事件的详情
+func segmentData(e SegmentEvent) map[string]any {
+	data := map[string]any{
+		"uri":                    e.URI,
+		"sequence":               e.Sequence,
+		"discontinuity_sequence": e.DiscontinuitySequence,
+		"discontinuity":          e.Discontinuity,
+		"duration":               e.Duration.Seconds(),
+		"file":                   e.File,
+		"size":                   e.Size,
+		"checksum":               e.Checksum,
+		"attempts":               e.Attempts,
+		"error":                  errorText(e.Err),
+	}
+	if !e.ProgramDateTime.IsZero() {
+		data["program_date_time"] = e.ProgramDateTime
+	}
+	return data
+}
+
+// RecordingStarted 实现 Observer
+func (w *WebhookObserver) RecordingStarted(e RecordingEvent) {
+	w.enqueue(EventRecordingStarted, "", map[string]any{
+		"url":        e.URL,
+		"output_dir": e.OutputDir,
+		"tracks":     e.Tracks,
+	})
+}
+
+// PlaylistReloaded 实现 Observer
+func (w *WebhookObserver) PlaylistReloaded(e PlaylistEvent) {
+	w.enqueue(EventPlaylistReloaded, e.Track, map[string]any{
+		"url":             e.URL,
+		"media_sequence":  e.MediaSequence,
+		"segments":        e.Segments,
+		"target_duration": e.TargetDuration.Seconds(),
+		"changed":         e.Changed,
+		"ended":           e.Ended,
+	})
+}
+
+// VariantSwitched 实现 Observer
+func (w *WebhookObserver) VariantSwitched(e VariantEvent) {
+	w.enqueue(EventVariantSwitched, "main", map[string]any{
+		"master_url": e.MasterURL,
+		"uri":        e.URI,
+		"policy":     e.Policy,
+		"variants":   e.Variants,
+		"bandwidth":  e.Bandwidth,
+		"width":      e.Width,
+		"height":     e.Height,
+		"codecs":     e.Codecs,
+	})
+}
+
+// SegmentStarted 实现 Observer
+func (w *WebhookObserver) SegmentStarted(e SegmentEvent) {
+	w.enqueue(EventSegmentStarted, e.Track, segmentData(e))
+}
+
+// SegmentCompleted 实现 Observer
+func (w *WebhookObserver) SegmentCompleted(e SegmentEvent) {
+	w.enqueue(EventSegmentCompleted, e.Track, segmentData(e))
+}
+
+// SegmentFailed 实现 Observer
+func (w *WebhookObserver) SegmentFailed(e SegmentEvent) {
+	w.enqueue(EventSegmentFailed, e.Track, segmentData(e))
+}
+
+// Discontinuity 实现 Observer
+func (w *WebhookObserver) Discontinuity(e SegmentEvent) {
+	w.enqueue(EventDiscontinuity, e.Track, segmentData(e))
+}
+
+// StreamEnded 实现 Observer
+func (w *WebhookObserver) StreamEnded(e StreamEvent) {
+	w.enqueue(EventStreamEnded, e.Track, map[string]any{
+		"url":    e.URL,
+		"queued": e.Queued,
+		"bytes":  e.Bytes,
+		"lost":   e.Lost,
+		"error":  errorText(e.Err),
+	})
+}
+
+// Stalled 实现 Observer
+func (w *WebhookObserver) Stalled(e StallEvent) {
+	w.enqueue(EventStalled, e.Track, map[string]any{
+		"url":             e.URL,
+		"stalled_for":     e.Since.Seconds(),
+		"target_duration": e.TargetDuration.Seconds(),
+	})
+}
+
+// RepeatedFailure 实现 Observer
+func (w *WebhookObserver) RepeatedFailure(e FailureEvent) {
+	w.enqueue(EventRepeatedFailure, e.Track, map[string]any{
+		"url":      e.URL,
+		"failures": e.Count,
+		"error":    errorText(e.Err),
+	})
+}
+
+// DiskQuotaExceeded 实现 Observer
+func (w *WebhookObserver) DiskQuotaExceeded(e QuotaEvent) {
+	w.enqueue(EventDiskQuota, "", map[string]any{
+		"output_dir": e.OutputDir,
+		"quota":      e.Limit,
+		"used":       e.Used,
+	})
+}
+
+// Error 实现 Observer
+func (w *WebhookObserver) Error(e ErrorEvent) {
+	w.enqueue(EventError, e.Track, map[string]any{"error": errorText(e.Err)})
+}
@@ -0,0 +1,272 @@
+package downloader
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookDelivery 接收方收到的一次请求
+type webhookDelivery struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver 记录收到的请求，respond 决定每次请求的状态码
+type webhookReceiver struct {
+	*httptest.Server
+	mu         sync.Mutex
+	deliveries []webhookDelivery
+}
+
+func newWebhookReceiver(t *testing.T, respond func(n int) int) *webhookReceiver {
+	r := &webhookReceiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.deliveries = append(r.deliveries, webhookDelivery{header: req.Header.Clone(), body: body})
+		n := len(r.deliveries)
+		r.mu.Unlock()
+		w.WriteHeader(respond(n))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) received() []webhookDelivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.deliveries)
+}
+
+func newTestWebhook(t *testing.T, url string, modify func(*WebhookOptions)) *WebhookObserver {
+	t.Helper()
+	options := DefaultWebhookOptions()
+	options.URL = url
+	options.RetryDelay = time.Millisecond
+	if modify != nil {
+		modify(&options)
+	}
+	w, err := NewWebhookObserver(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func respondOK(int) int { return http.StatusNoContent }
+
+// 请求体是事件的 JSON，签名覆盖时间戳和请求体
+func TestWebhookPayloadAndSignature(t *testing.T) {
+	receiver := newWebhookReceiver(t, respondOK)
+	w := newTestWebhook(t, receiver.URL, func(o *WebhookOptions) { o.Secret = "s3cret" })
+
+	w.SegmentCompleted(SegmentEvent{Track: "main"}) // 默认不发送片段事件
+	w.StreamEnded(StreamEvent{Track: "main", URL: "https://example.com/live.m3u8", Queued: 12, Bytes: 3456, Lost: 1})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := receiver.received()
+	if len(deliveries) != 1 {
+		t.Fatalf("received %d requests, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+
+	var payload struct {
+		ID    string         `json:"id"`
+		Event string         `json:"event"`
+		Time  time.Time      `json:"time"`
+		Track string         `json:"track"`
+		Data  map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(d.body, &payload); err != nil {
+		t.Fatalf("body is not JSON: %v\n%s", err, d.body)
+	}
+	if payload.Event != EventStreamEnded || payload.Track != "main" || payload.ID == "" || payload.Time.IsZero() {
+		t.Errorf("payload %+v", payload)
+	}
+	if payload.Data["url"] != "https://example.com/live.m3u8" || payload.Data["queued"] != 12.0 || payload.Data["bytes"] != 3456.0 || payload.Data["lost"] != 1.0 {
+		t.Errorf("payload data %v", payload.Data)
+	}
+	if got := d.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type %q", got)
+	}
+	if d.header.Get("X-HLS-Event") != EventStreamEnded || d.header.Get("X-HLS-Delivery") != payload.ID {
+		t.Errorf("event headers %v", d.header)
+	}
+
+	timestamp := d.header.Get("X-HLS-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)).Abs() > time.Minute {
+		t.Fatalf("X-HLS-Timestamp %q is not the current Unix time", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(d.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); d.header.Get("X-HLS-Signature") != want {
+		t.Errorf("X-HLS-Signature %q, want %q", d.header.Get("X-HLS-Signature"), want)
+	}
+}
+
+// 没有设置密钥时不签名
+func TestWebhookWithoutSecret(t *testing.T) {
+	receiver := newWebhookReceiver(t, respondOK)
+	w := newTestWebhook(t, receiver.URL, nil)
+	w.RecordingStarted(RecordingEvent{URL: "https://example.com/live.m3u8", Tracks: []string{"main"}})
+	w.Close()
+
+	deliveries := receiver.received()
+	if len(deliveries) != 1 {
+		t.Fatalf("received %d requests, want 1", len(deliveries))
+	}
+	if h := deliveries[0].header; h.Get("X-HLS-Signature") != "" || h.Get("X-HLS-Timestamp") != "" {
+		t.Errorf("unsigned webhook sent signature headers %v", h)
+	}
+}
+
+// 5xx 和 429 会重试，重试使用同一个事件ID；4xx 不重试
+func TestWebhookRetries(t *testing.T) {
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusOK}
+	receiver := newWebhookReceiver(t, func(n int) int { return statuses[min(n, len(statuses))-1] })
+	w := newTestWebhook(t, receiver.URL, func(o *WebhookOptions) { o.Secret = "s3cret" })
+	w.StreamEnded(StreamEvent{Track: "main"})
+	w.Close()
+
+	deliveries := receiver.received()
+	if len(deliveries) != len(statuses) {
+		t.Fatalf("received %d attempts, want %d", len(deliveries), len(statuses))
+	}
+	for i, d := range deliveries {
+		if got := d.header.Get("X-HLS-Attempt"); got != strconv.Itoa(i+1) {
+			t.Errorf("attempt %d: X-HLS-Attempt %q", i+1, got)
+		}
+		if d.header.Get("X-HLS-Delivery") != deliveries[0].header.Get("X-HLS-Delivery") {
+			t.Errorf("attempt %d changed the delivery ID", i+1)
+		}
+	}
+
+	rejected := newWebhookReceiver(t, func(int) int { return http.StatusNotFound })
+	w = newTestWebhook(t, rejected.URL, nil)
+	w.StreamEnded(StreamEvent{Track: "main"})
+	w.Close()
+	if n := len(rejected.received()); n != 1 {
+		t.Errorf("404 was attempted %d times, want 1", n)
+	}
+
+	down := newWebhookReceiver(t, func(int) int { return http.StatusBadGateway })
+	w = newTestWebhook(t, down.URL, func(o *WebhookOptions) { o.MaxAttempts = 3 })
+	w.StreamEnded(StreamEvent{Track: "main"})
+	w.Close()
+	if n := len(down.received()); n != 3 {
+		t.Errorf("502 was attempted %d times, want MaxAttempts 3", n)
+	}
+}
+
+// 接收方很慢时队列会满，之后的事件被丢弃而不是阻塞录制
+func TestWebhookDropsWhenQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	receiver := newWebhookReceiver(t, func(int) int {
+		<-release
+		return http.StatusOK
+	})
+	w := newTestWebhook(t, receiver.URL, func(o *WebhookOptions) { o.QueueSize = 2 })
+
+	const events = 20
+	start := time.Now()
+	for i := 0; i < events; i++ {
+		w.StreamEnded(StreamEvent{Track: strconv.Itoa(i)})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("enqueueing %d events took %v, recording would stall", events, elapsed)
+	}
+
+	w.mu.Lock()
+	dropped := w.dropped
+	w.mu.Unlock()
+	// 发送协程最多取走一个事件，其余超出队列长度的都被丢弃
+	if dropped < events-2-1 {
+		t.Errorf("dropped %d events, want at least %d", dropped, events-2-1)
+	}
+
+	close(release)
+	w.Close()
+	if n := len(receiver.received()); n != events-dropped {
+		t.Errorf("received %d events, want %d (dropped %d)", n, events-dropped, dropped)
+	}
+}
+
+// Close 等待队列中的事件全部发送完，之后的事件被忽略
+func TestWebhookCloseDrainsQueue(t *testing.T) {
+	receiver := newWebhookReceiver(t, func(int) int {
+		time.Sleep(10 * time.Millisecond)
+		return http.StatusOK
+	})
+	w := newTestWebhook(t, receiver.URL, nil)
+
+	for i := 0; i < 5; i++ {
+		w.StreamEnded(StreamEvent{Track: strconv.Itoa(i)})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	deliveries := receiver.received()
+	if len(deliveries) != 5 {
+		t.Fatalf("received %d events before Close returned, want 5", len(deliveries))
+	}
+	// 事件按发生的顺序发送
+	for i, d := range deliveries {
+		var payload webhookPayload
+		json.Unmarshal(d.body, &payload)
+		if payload.Track != strconv.Itoa(i) {
+			t.Errorf("event %d has track %q", i, payload.Track)
+		}
+	}
+
+	w.StreamEnded(StreamEvent{Track: "late"})
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if n := len(receiver.received()); n != 5 {
+		t.Errorf("event after Close was sent (%d requests)", n)
+	}
+}
+
+func TestWebhookOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*WebhookOptions)
+		want   string // 错误信息中应包含的内容，空表示有效
+	}{
+		{name: "defaults", modify: func(o *WebhookOptions) {}},
+		{name: "events", modify: func(o *WebhookOptions) { o.Events = []string{EventSegmentCompleted, EventError} }},
+		{name: "no scheme", modify: func(o *WebhookOptions) { o.URL = "example.com/hook" }, want: "example.com/hook"},
+		{name: "ftp", modify: func(o *WebhookOptions) { o.URL = "ftp://example.com/hook" }, want: "ftp://example.com/hook"},
+		{name: "no host", modify: func(o *WebhookOptions) { o.URL = "https:///hook" }, want: "https:///hook"},
+		{name: "attempts", modify: func(o *WebhookOptions) { o.MaxAttempts = 0 }, want: "最大尝试次数"},
+		{name: "queue", modify: func(o *WebhookOptions) { o.QueueSize = 0 }, want: "队列长度"},
+		{name: "unknown event", modify: func(o *WebhookOptions) { o.Events = []string{"segment_done"} }, want: "segment_done"},
+	}
+	for _, tt := range tests {
+		options := DefaultWebhookOptions()
+		options.URL = "https://example.com/hook"
+		tt.modify(&options)
+		err := options.Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: err = %v, want mention of %q", tt.name, err, tt.want)
+		}
+	}
+}
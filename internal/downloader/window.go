@@ -0,0 +1,177 @@
+package downloader  // 下载器包，负责HLS流下载的核心逻辑
+
+// segmentStatus 片段在去重窗口中的下载状态
+type segmentStatus int
+
+const (
+	segmentPending  segmentStatus = iota // 已发现，还没开始下载
+	segmentInFlight                      // 正在下载
+	segmentDone                          // 下载成功
+	segmentFailed                        // 重试用尽仍然失败，仍在列表中时下次刷新再试
+)
+
+// segmentEntry 窗口中一个片段的下载状态
+type segmentEntry struct {
+	seq      int           // 媒体序列号
+	uri      string        // 片段地址
+	status   segmentStatus // 当前状态
+	attempts int           // 已经尝试下载的轮数（每轮最多包含 MaxRetryAttempts 次请求）
+	lastErr  error         // 最近一次失败的原因
+}
+
+// lostSegment 没有下载成功就滑出列表的片段，再也无法获取
+type lostSegment struct {
+	id       string
+	seq      int
+	uri      string
+	attempts int
+	lastErr  error
+}
+
+// segmentWindow 按媒体序列号滑动的去重窗口。
+// 直播列表只会从头部移除片段，序列号低于当前 EXT-X-MEDIA-SEQUENCE 的片段不会再出现，
+// 因此只需记住窗口内的片段，长时间录制时内存占用保持在一个列表窗口的大小
+type segmentWindow struct {
+	floor int                      // 窗口下沿：已见过的最大 EXT-X-MEDIA-SEQUENCE
+	seen  map[string]*segmentEntry // 窗口内的片段ID → 下载状态
+}
+
+// newSegmentWindow 创建空的去重窗口
+func newSegmentWindow() *segmentWindow {
+	return &segmentWindow{seen: make(map[string]*segmentEntry)}
+}
+
+// advance 按新列表的起始序列号移动窗口，丢弃已经滑出列表的片段，返回其中没有下载成功的。
+// 新列表整体落后窗口超过一个列表长度时认为序列号被重置（如推流重启），清空窗口并返回 reset；
+// 只落后一点的视为 CDN 返回的过期列表，窗口不后退
+func (w *segmentWindow) advance(mediaSeq, count int) (reset bool, lost []lostSegment) {
+	if mediaSeq < w.floor {
+		if mediaSeq+2*count > w.floor {
+			return false, nil
+		}
+		lost = w.evict(func(*segmentEntry) bool { return true })
+		w.floor = mediaSeq
+		return true, lost
+	}
+
+	w.floor = mediaSeq
+	return false, w.evict(func(e *segmentEntry) bool { return e.seq < w.floor })
+}
+
+// evict 删除满足条件的片段，返回其中没有下载成功的
+func (w *segmentWindow) evict(match func(*segmentEntry) bool) []lostSegment {
+	var lost []lostSegment
+	for id, e := range w.seen {
+		if !match(e) {
+			continue
+		}
+		if e.status != segmentDone {
+			lost = append(lost, lostSegment{id: id, seq: e.seq, uri: e.uri, attempts: e.attempts, lastErr: e.lastErr})
+		}
+		delete(w.seen, id)
+	}
+	return lost
+}
+
+// needsDownload 判断片段是否需要下载：新片段、上次没来得及下载或下载失败的片段都需要；
+// 低于窗口下沿的片段已经处理过
+func (w *segmentWindow) needsDownload(id string, seq int) bool {
+	if seq < w.floor {
+		return false
+	}
+	e, ok := w.seen[id]
+	return !ok || e.status == segmentPending || e.status == segmentFailed
+}
+
+// retrying 判断片段是否是之前下载失败后的重试
+func (w *segmentWindow) retrying(id string) bool {
+	e, ok := w.seen[id]
+	return ok && e.status == segmentFailed
+}
+
+// add 记录发现的片段，状态为 pending
+func (w *segmentWindow) add(id string, seq int, uri string) {
+	if seq < w.floor {
+		return
+	}
+	if _, ok := w.seen[id]; !ok {
+		w.seen[id] = &segmentEntry{seq: seq, uri: uri}
+	}
+}
+
+// markDone 直接把片段记为下载成功，用于从续录状态恢复
+func (w *segmentWindow) markDone(id string, seq int, uri string) {
+	w.add(id, seq, uri)
+	if e, ok := w.seen[id]; ok {
+		e.status = segmentDone
+	}
+}
+
+// restoreFloor 从续录状态恢复后，把窗口下沿设为记录中最小的序列号。
+// 推流重启使序列号重置时，第一次刷新就会按重置处理并清空这些记录；
+// 否则序列号更大的旧记录会一直是已下载状态，ID 相同的新片段会被跳过
+func (w *segmentWindow) restoreFloor() {
+	first := true
+	for _, e := range w.seen {
+		if first || e.seq < w.floor {
+			w.floor = e.seq
+			first = false
+		}
+	}
+}
+
+// start 把片段标记为正在下载
+func (w *segmentWindow) start(id string) {
+	if e, ok := w.seen[id]; ok {
+		e.status = segmentInFlight
+		e.attempts++
+	}
+}
+
+// finish 按下载结果把片段标记为成功或失败
+func (w *segmentWindow) finish(id string, err error) {
+	e, ok := w.seen[id]
+	if !ok {
+		return
+	}
+	if err != nil {
+		e.status = segmentFailed
+		e.lastErr = err
+		return
+	}
+	e.status = segmentDone
+	e.lastErr = nil
+}
+
+// done 判断片段是否已经下载成功
+func (w *segmentWindow) done(id string) bool {
+	e, ok := w.seen[id]
+	return ok && e.status == segmentDone
+}
+
+// unfinished 返回窗口内还没下载成功的片段，用于结束时的汇总
+func (w *segmentWindow) unfinished() []lostSegment {
+	var lost []lostSegment
+	for id, e := range w.seen {
+		if e.status != segmentDone {
+			lost = append(lost, lostSegment{id: id, seq: e.seq, uri: e.uri, attempts: e.attempts, lastErr: e.lastErr})
+		}
+	}
+	return lost
+}
+
+// failed 返回窗口内下载失败、等待重试的片段数
+func (w *segmentWindow) failed() int {
+	n := 0
+	for _, e := range w.seen {
+		if e.status == segmentFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// len 返回窗口内记录的片段数
+func (w *segmentWindow) len() int {
+	return len(w.seen)
+}
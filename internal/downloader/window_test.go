@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/MGter/hls_downloader/internal/storage"
+)
+
+// 模拟几个月的直播：列表长度固定为 listLen，序列号一直增长，中途推流重启一次，并不时收到 CDN 返回的过期列表。
+// 去重窗口和续录记录都只能保留一个列表窗口的片段，每个片段只下载一次
+func TestSegmentWindowMemoryStaysFlat(t *testing.T) {
+	const listLen = 10
+	steps := 1_000_000 // 6秒一个片段约两个半月
+	if testing.Short() {
+		steps = 100_000
+	}
+	resetAt := steps / 2
+
+	d := New()
+	track := newMediaTrack("main", "https://example.com/live.m3u8", t.TempDir())
+	track.state = &storage.ResumeState{}
+	w := track.window
+
+	downloads := 0
+	maxRecords := 0
+	disc, base := 0, 1_000_000_000
+	refresh := func(mediaSeq int) {
+		reset, lost := w.advance(mediaSeq, listLen)
+		if len(lost) > 0 {
+			t.Fatalf("seq %d: %d segments reported lost", mediaSeq, len(lost))
+		}
+		if reset && mediaSeq != 0 {
+			t.Fatalf("seq %d: unexpected reset", mediaSeq)
+		}
+
+		var ids []string
+		var results []storage.DownloadResult
+		for seq := mediaSeq; seq < mediaSeq+listLen; seq++ {
+			id := strconv.Itoa(disc) + ":" + strconv.Itoa(seq)
+			if !w.needsDownload(id, seq) {
+				continue
+			}
+			w.add(id, seq, "seg.ts")
+			w.start(id)
+			w.finish(id, nil)
+			downloads++
+			ids = append(ids, id)
+			results = append(results, storage.DownloadResult{Task: storage.SegmentTask{Sequence: seq}})
+		}
+
+		// 续录记录按窗口裁剪；为了不在每一步都写文件，结果里只有文件名非空的才会追加
+		if mediaSeq%10_000 == 0 {
+			for i := range results {
+				results[i].Filename = "seg" + strconv.Itoa(i) + ".ts"
+			}
+		}
+		d.saveResumeState(track, ids, results)
+		maxRecords = max(maxRecords, len(track.state.Segments))
+	}
+
+	for step := 0; step < steps; step++ {
+		if step == resetAt {
+			disc, base = disc+1, -step // 推流重启，序列号从0开始
+		}
+		mediaSeq := base + step
+		refresh(mediaSeq)
+		if step%1000 == 999 && mediaSeq >= 3 {
+			refresh(mediaSeq - 3) // 过期列表，窗口不能后退，也不能重复下载
+		}
+
+		if w.len() > listLen {
+			t.Fatalf("step %d: window holds %d entries, want at most %d", step, w.len(), listLen)
+		}
+	}
+
+	if want := steps + 2*(listLen-1); downloads != want {
+		t.Errorf("downloaded %d segments, want %d (each exactly once)", downloads, want)
+	}
+	if maxRecords > listLen {
+		t.Errorf("resume state grew to %d records, want at most %d", maxRecords, listLen)
+	}
+}
+
+// 只落后一点的过期列表不能让窗口后退，落后超过两个列表长度时按序列号重置处理
+func TestSegmentWindowAdvance(t *testing.T) {
+	w := newSegmentWindow()
+	w.advance(100, 10)
+	for seq := 100; seq < 110; seq++ {
+		w.add("0:"+strconv.Itoa(seq), seq, "")
+		w.start("0:" + strconv.Itoa(seq))
+		w.finish("0:"+strconv.Itoa(seq), nil)
+	}
+
+	if reset, _ := w.advance(95, 10); reset || w.floor != 100 {
+		t.Fatalf("stale playlist: reset=%v floor=%d, want no reset and floor 100", reset, w.floor)
+	}
+	if w.needsDownload("0:96", 96) {
+		t.Error("segment below the floor must not be downloaded again")
+	}
+
+	if reset, lost := w.advance(105, 10); reset || len(lost) != 0 || w.len() != 5 {
+		t.Fatalf("advance: reset=%v lost=%d len=%d, want no reset, nothing lost, 5 entries", reset, len(lost), w.len())
+	}
+
+	w.add("0:106x", 106, "") // 没有下载成功就滑出列表
+	if _, lost := w.advance(107, 10); len(lost) != 1 || lost[0].id != "0:106x" {
+		t.Fatalf("lost = %v, want the pending segment 0:106x", lost)
+	}
+
+	if reset, _ := w.advance(0, 10); !reset || w.len() != 0 || w.floor != 0 {
+		t.Fatalf("sequence reset: reset=%v len=%d floor=%d, want reset with an empty window", reset, w.len(), w.floor)
+	}
+}
@@ -0,0 +1,52 @@
+package parser  // 解析器包，负责解析M3U8文件
+
+import (
+	"strings"
+)
+
+// ParseAttributes 解析标签的属性列表，例如 `METHOD=AES-128,URI="key.bin",IV=0x1A`
+// 引号内的逗号和等号不会被当作分隔符，返回的值已去掉两侧引号
+func ParseAttributes(value string) map[string]string {
+	attrs := make(map[string]string)
+
+	for len(value) > 0 {
+		// 读取属性名，直到等号
+		eq := strings.IndexByte(value, '=')
+		if eq < 0 {
+			break // 没有等号，剩余内容不是合法属性
+		}
+		name := strings.ToUpper(strings.TrimSpace(value[:eq]))
+		value = value[eq+1:]
+
+		var attrValue string
+		if strings.HasPrefix(value, `"`) {
+			// 带引号的字符串：一直读到下一个引号
+			end := strings.IndexByte(value[1:], '"')
+			if end < 0 {
+				attrValue, value = value[1:], "" // 缺少结束引号，容错取到行尾
+			} else {
+				attrValue, value = value[1:end+1], value[end+2:]
+			}
+			// 跳过引号后直到逗号的内容
+			if comma := strings.IndexByte(value, ','); comma >= 0 {
+				value = value[comma+1:]
+			} else {
+				value = ""
+			}
+		} else {
+			// 普通值：读到下一个逗号
+			if comma := strings.IndexByte(value, ','); comma >= 0 {
+				attrValue, value = value[:comma], value[comma+1:]
+			} else {
+				attrValue, value = value, ""
+			}
+			attrValue = strings.TrimSpace(attrValue)
+		}
+
+		if name != "" {
+			attrs[name] = attrValue
+		}
+	}
+
+	return attrs
+}
@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseAttributes(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]string
+	}{
+		{
+			name:  "key",
+			value: `METHOD=AES-128,URI="key.bin",IV=0x1A`,
+			want:  map[string]string{"METHOD": "AES-128", "URI": "key.bin", "IV": "0x1A"},
+		},
+		{
+			name:  "quoted comma",
+			value: `BANDWIDTH=1280000,CODECS="avc1.64001f,mp4a.40.2",RESOLUTION=1280x720`,
+			want:  map[string]string{"BANDWIDTH": "1280000", "CODECS": "avc1.64001f,mp4a.40.2", "RESOLUTION": "1280x720"},
+		},
+		{
+			name:  "quoted equals",
+			value: `URI="key?id=1&v=2",METHOD=AES-128`,
+			want:  map[string]string{"URI": "key?id=1&v=2", "METHOD": "AES-128"},
+		},
+		{
+			name:  "lowercase name and spaces",
+			value: `type=AUDIO, GROUP-ID="aud"`,
+			want:  map[string]string{"TYPE": "AUDIO", "GROUP-ID": "aud"},
+		},
+		{
+			name:  "missing closing quote",
+			value: `NAME="English,LANGUAGE=en`,
+			want:  map[string]string{"NAME": "English,LANGUAGE=en"},
+		},
+		{
+			name:  "empty",
+			value: ``,
+			want:  map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		if got := ParseAttributes(tt.value); !maps.Equal(got, tt.want) {
+			t.Errorf("%s: ParseAttributes(%q) = %v, want %v", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
@@ -2,35 +2,81 @@ package parser  // 解析器包，负责解析M3U8文件
 
 import (
 	"bufio"
+	"encoding/hex"
 	"fmt"
 	"net/url"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Playlist 播放列表结构体，存储解析结果
 type Playlist struct {
-	URLs          []string  // 提取出的所有URL
-	IsMaster      bool      // 是否是主播放列表（master playlist）
-	MediaSequence int       // 媒体序列号，用于片段排序
+	URLs                  []string // 提取出的所有URL
+	IsMaster              bool     // 是否是主播放列表（master playlist）
+	MediaSequence         int      // 媒体序列号，用于片段排序
+	DiscontinuitySequence int      // EXT-X-DISCONTINUITY-SEQUENCE，第一个片段的不连续序列号
+
+	// 以下字段仅对主播放列表（master playlist）有效
+	Variants   []Variant   // EXT-X-STREAM-INF 描述的各个码率版本
+	Renditions []Rendition // EXT-X-MEDIA 描述的备用音频/字幕等渲染
+
+	// 以下字段仅对媒体播放列表（media playlist）有效
+	Segments       []Segment // 按出现顺序排列的媒体片段
+	TargetDuration int       // EXT-X-TARGETDURATION，片段最大时长（秒）
+	PlaylistType   string    // EXT-X-PLAYLIST-TYPE：VOD / EVENT，直播流为空
+	EndList        bool      // 是否出现 EXT-X-ENDLIST，即列表不会再增长
+	Version        int       // EXT-X-VERSION，协议版本号
+}
+
+// Segment 媒体片段，包含 URI 以及作用于它的各个标签信息
+type Segment struct {
+	URI                   string     // 片段的绝对URL
+	Duration              float64    // EXTINF 时长（秒）
+	Title                 string     // EXTINF 逗号后的标题，可能为空
+	MediaSequence         int        // 片段的绝对媒体序列号
+	Discontinuity         bool       // 片段前是否有 EXT-X-DISCONTINUITY
+	DiscontinuitySequence int        // 片段的绝对不连续序列号，每经过一个 EXT-X-DISCONTINUITY 加一
+	ByteRange             *ByteRange // EXT-X-BYTERANGE，nil 表示下载整个资源
+	Key                   *Key       // 当前生效的 EXT-X-KEY，nil 表示未加密
+	Map                   *Map       // 当前生效的 EXT-X-MAP（初始化片段），nil 表示没有
+	ProgramDateTime       time.Time  // 片段第一帧的绝对时间，零值表示未知
+}
+
+// ByteRange 字节范围，对应 EXT-X-BYTERANGE 的 <n>[@<o>]
+type ByteRange struct {
+	Length int64 // 长度（字节）
+	Offset int64 // 起始偏移（字节），省略时已按上一个片段推算好
+}
+
+// Key 加密信息，对应 EXT-X-KEY
+type Key struct {
+	Method            string // 加密方式：AES-128 / SAMPLE-AES 等
+	URI               string // 密钥的绝对URL
+	IV                []byte // 显式指定的16字节IV，nil 表示需要按媒体序列号推导
+	KeyFormat         string // KEYFORMAT，省略时为 "identity"
+	KeyFormatVersions string // KEYFORMATVERSIONS
+}
+
+// Map 初始化片段信息，对应 EXT-X-MAP
+type Map struct {
+	URI       string     // 初始化片段的绝对URL
+	ByteRange *ByteRange // 初始化片段的字节范围，nil 表示整个资源
 }
 
 // M3U8Parser M3U8文件解析器
 type M3U8Parser struct {
 	segmentNumberRegex *regexp.Regexp  // 正则表达式：从文件名提取数字
-	mediaSequenceRegex *regexp.Regexp  // 正则表达式：提取媒体序列号
 }
 
 // NewM3U8Parser 创建新的解析器
 func NewM3U8Parser() *M3U8Parser {
-	// 编译两个正则表达式，用于后续匹配
+	// 编译正则表达式，用于后续匹配
 	return &M3U8Parser{
 		// 匹配文件名末尾的数字，例如 "segment123.ts" 中的 "123"
 		segmentNumberRegex: regexp.MustCompile(`(\d+)$`),
-		// 匹配 M3U8 文件中的媒体序列号标签
-		mediaSequenceRegex: regexp.MustCompile(`#EXT-X-MEDIA-SEQUENCE:(\d+)`),
 	}
 }
 
@@ -50,55 +96,85 @@ func (p *M3U8Parser) Parse(content, baseURL string) (*Playlist, error) {
 		return nil, fmt.Errorf("无法识别 M3U8 列表类型")
 	}
 
-	// 提取媒体序列号（如果存在）
-	mediaSeq := p.extractMediaSequence(content)
-
 	// 解析基础URL，用于后续相对路径转换
 	base, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("解析基础 URL 失败: %w", err)
 	}
 
-	// 从内容中提取所有URL
-	urls, err := p.extractURLsFromContent(content, base)
-	if err != nil {
+	// 逐行解析标签和URL
+	playlist := &Playlist{IsMaster: isMasterPlaylist}
+	if err := p.parseLines(content, base, playlist); err != nil {
 		return nil, err
 	}
 
-	// 返回解析结果
-	return &Playlist{
-		URLs:          urls,           // 提取的URL列表
-		IsMaster:      isMasterPlaylist, // 播放列表类型
-		MediaSequence: mediaSeq,       // 媒体序列号
-	}, nil
+	return playlist, nil
 }
 
-// extractMediaSequence 从M3U8内容中提取媒体序列号
-func (p *M3U8Parser) extractMediaSequence(content string) int {
-	// 使用正则表达式查找媒体序列号标签
-	match := p.mediaSequenceRegex.FindStringSubmatch(content)
-	if len(match) > 1 {
-		// 将匹配到的字符串转换为整数
-		if seq, err := strconv.Atoi(match[1]); err == nil {
-			return seq
-		}
-	}
-	// 如果没有找到，返回0
-	return 0
+// Variant 主播放列表中的一个码率版本，对应 EXT-X-STREAM-INF 及其后的URI
+type Variant struct {
+	URI              string   // 媒体播放列表的绝对URL
+	Bandwidth        int      // BANDWIDTH，峰值码率（bit/s）
+	AverageBandwidth int      // AVERAGE-BANDWIDTH，平均码率（bit/s），0 表示未提供
+	Width            int      // RESOLUTION 的宽度，0 表示未提供
+	Height           int      // RESOLUTION 的高度，0 表示未提供
+	Codecs           []string // CODECS，例如 ["avc1.64001f", "mp4a.40.2"]
+	FrameRate        float64  // FRAME-RATE，0 表示未提供
+	HDCPLevel        string   // HDCP-LEVEL：TYPE-0 / TYPE-1 / NONE
+	VideoRange       string   // VIDEO-RANGE：SDR / HLG / PQ
+	Audio            string   // AUDIO 渲染组ID
+	Subtitles        string   // SUBTITLES 渲染组ID
+	ClosedCaptions   string   // CLOSED-CAPTIONS 渲染组ID，或 "NONE"
 }
 
-// extractURLsFromContent 从M3U8内容中提取URL
-func (p *M3U8Parser) extractURLsFromContent(content string, baseURL *url.URL) ([]string, error) {
-	var urls []string
+// Rendition 主播放列表中的备用渲染，对应 EXT-X-MEDIA
+type Rendition struct {
+	Type            string // TYPE：AUDIO / VIDEO / SUBTITLES / CLOSED-CAPTIONS
+	GroupID         string // GROUP-ID，由 Variant 的 AUDIO/SUBTITLES 等属性引用
+	URI             string // 媒体播放列表的绝对URL，为空表示已混合在主码流中
+	Language        string // LANGUAGE，例如 "en"、"zh-Hans"
+	Name            string // NAME，人类可读的名称
+	Default         bool   // DEFAULT=YES
+	Autoselect      bool   // AUTOSELECT=YES
+	Forced          bool   // FORCED=YES（仅字幕）
+	Characteristics string // CHARACTERISTICS
+	Channels        string // CHANNELS（仅音频），例如 "2"、"6"
+	InstreamID      string // INSTREAM-ID（仅 CLOSED-CAPTIONS）
+}
+
+// segmentState 解析媒体列表时，记录尚未落到某个片段上的标签
+type segmentState struct {
+	nextSeq         int        // 下一个片段的媒体序列号
+	discSeq         int        // 当前的不连续序列号
+	duration        float64    // 最近一次 EXTINF 的时长
+	title           string     // 最近一次 EXTINF 的标题
+	discontinuity   bool       // 是否遇到 EXT-X-DISCONTINUITY
+	byteRange       *ByteRange // 仅作用于下一个片段的字节范围
+	key             *Key       // 持续生效直到下一个 EXT-X-KEY
+	segMap          *Map       // 持续生效直到下一个 EXT-X-MAP
+	programDateTime time.Time  // 仅作用于下一个片段的绝对时间
+	streamInf       *Variant   // 等待URI的 EXT-X-STREAM-INF
+	prev            *Segment   // 上一个片段，用于推算字节偏移和时间
+}
+
+// parseLines 逐行解析M3U8内容，填充播放列表
+func (p *M3U8Parser) parseLines(content string, baseURL *url.URL, playlist *Playlist) error {
+	state := &segmentState{}
 	// 使用扫描器逐行读取内容
 	scanner := bufio.NewScanner(strings.NewReader(content))
 
 	for scanner.Scan() {
 		// 读取一行并去除空白字符
 		line := strings.TrimSpace(scanner.Text())
-		
-		// 跳过注释行和空行
-		if strings.HasPrefix(line, "#") || line == "" {
+
+		// 跳过空行
+		if line == "" {
+			continue
+		}
+
+		// 标签行：更新状态后继续
+		if strings.HasPrefix(line, "#") {
+			p.parseTag(line, baseURL, playlist, state)
 			continue
 		}
 
@@ -111,15 +187,328 @@ func (p *M3U8Parser) extractURLsFromContent(content string, baseURL *url.URL) ([
 		}
 
 		// 将解析成功的URL添加到列表
-		urls = append(urls, parsedURL)
+		playlist.URLs = append(playlist.URLs, parsedURL)
+		if !playlist.IsMaster {
+			playlist.Segments = append(playlist.Segments, state.takeSegment(parsedURL))
+		} else if state.streamInf != nil {
+			// EXT-X-STREAM-INF 后的第一个URI就是该码率版本的地址
+			variant := *state.streamInf
+			variant.URI = parsedURL
+			playlist.Variants = append(playlist.Variants, variant)
+			state.streamInf = nil
+		}
 	}
 
 	// 检查扫描过程中是否有错误
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("扫描 M3U8 内容失败: %w", err)
+		return fmt.Errorf("扫描 M3U8 内容失败: %w", err)
+	}
+
+	return nil
+}
+
+// parseTag 解析单个标签行，更新播放列表或片段状态
+func (p *M3U8Parser) parseTag(line string, baseURL *url.URL, playlist *Playlist, state *segmentState) {
+	// 拆分标签名和值，例如 "#EXTINF:10.0," -> "#EXTINF" 和 "10.0,"
+	name, value, _ := strings.Cut(line, ":")
+
+	switch name {
+	case "#EXT-X-VERSION":
+		playlist.Version, _ = strconv.Atoi(value)
+	case "#EXT-X-TARGETDURATION":
+		playlist.TargetDuration, _ = strconv.Atoi(value)
+	case "#EXT-X-MEDIA-SEQUENCE":
+		if seq, err := strconv.Atoi(value); err == nil {
+			playlist.MediaSequence = seq
+			state.nextSeq = seq
+		}
+	case "#EXT-X-DISCONTINUITY-SEQUENCE":
+		if seq, err := strconv.Atoi(value); err == nil {
+			playlist.DiscontinuitySequence = seq
+			state.discSeq = seq
+		}
+	case "#EXT-X-PLAYLIST-TYPE":
+		playlist.PlaylistType = strings.ToUpper(value)
+	case "#EXT-X-ENDLIST":
+		playlist.EndList = true
+	case "#EXTINF":
+		// 格式：<时长>,[<标题>]
+		durationStr, title, _ := strings.Cut(value, ",")
+		state.duration, _ = strconv.ParseFloat(strings.TrimSpace(durationStr), 64)
+		state.title = strings.TrimSpace(title)
+	case "#EXT-X-DISCONTINUITY":
+		state.discontinuity = true
+	case "#EXT-X-BYTERANGE":
+		byteRange, err := parseByteRange(value)
+		if err != nil {
+			fmt.Printf("警告: 无法解析 BYTERANGE '%s': %v", value, err)
+			return
+		}
+		state.byteRange = byteRange
+	case "#EXT-X-PROGRAM-DATE-TIME":
+		if t, err := parseDateTime(value); err == nil {
+			state.programDateTime = t
+		}
+	case "#EXT-X-KEY":
+		key, err := p.parseKey(value, baseURL)
+		if err != nil {
+			fmt.Printf("警告: 无法解析 EXT-X-KEY '%s': %v", value, err)
+			return
+		}
+		state.key = key // METHOD=NONE 时为 nil，表示后续片段不再加密
+	case "#EXT-X-STREAM-INF":
+		state.streamInf = parseStreamInf(value)
+	case "#EXT-X-MEDIA":
+		rendition, err := p.parseMedia(value, baseURL)
+		if err != nil {
+			fmt.Printf("警告: 无法解析 EXT-X-MEDIA '%s': %v", value, err)
+			return
+		}
+		playlist.Renditions = append(playlist.Renditions, *rendition)
+	case "#EXT-X-MAP":
+		segMap, err := p.parseMap(value, baseURL)
+		if err != nil {
+			fmt.Printf("警告: 无法解析 EXT-X-MAP '%s': %v", value, err)
+			return
+		}
+		state.segMap = segMap
+	}
+}
+
+// takeSegment 用当前状态生成一个片段，并重置只作用于单个片段的标签
+func (s *segmentState) takeSegment(uri string) Segment {
+	// 不连续点之后的片段属于新的不连续序列
+	if s.discontinuity {
+		s.discSeq++
+	}
+
+	seg := Segment{
+		URI:                   uri,
+		Duration:              s.duration,
+		Title:                 s.title,
+		MediaSequence:         s.nextSeq,
+		Discontinuity:         s.discontinuity,
+		DiscontinuitySequence: s.discSeq,
+		ByteRange:             s.byteRange,
+		Key:                   s.key,
+		Map:                   s.segMap,
+		ProgramDateTime:       s.programDateTime,
+	}
+
+	if prev := s.prev; prev != nil {
+		// 省略偏移的字节范围从同一资源上一个范围的末尾继续
+		if seg.ByteRange != nil && seg.ByteRange.Offset < 0 {
+			seg.ByteRange.Offset = 0
+			if prev.ByteRange != nil && prev.URI == uri {
+				seg.ByteRange.Offset = prev.ByteRange.Offset + prev.ByteRange.Length
+			}
+		}
+		// 没有显式时间时，按上一个片段的时间和时长推算（不跨越不连续点）
+		if seg.ProgramDateTime.IsZero() && !prev.ProgramDateTime.IsZero() && !seg.Discontinuity {
+			seg.ProgramDateTime = prev.ProgramDateTime.Add(time.Duration(prev.Duration * float64(time.Second)))
+		}
+	}
+	if seg.ByteRange != nil && seg.ByteRange.Offset < 0 {
+		seg.ByteRange.Offset = 0 // 第一个片段省略偏移时从资源开头开始
+	}
+
+	// 重置只作用于单个片段的状态
+	s.nextSeq++
+	s.duration = 0
+	s.title = ""
+	s.discontinuity = false
+	s.byteRange = nil
+	s.programDateTime = time.Time{}
+	s.prev = &seg
+
+	return seg
+}
+
+// parseKey 解析 EXT-X-KEY 的属性列表
+func (p *M3U8Parser) parseKey(value string, baseURL *url.URL) (*Key, error) {
+	attrs := ParseAttributes(value)
+
+	method := attrs["METHOD"]
+	if method == "" {
+		return nil, fmt.Errorf("缺少 METHOD 属性")
+	}
+	if method == "NONE" {
+		return nil, nil // 明确声明不加密
+	}
+
+	key := &Key{
+		Method:            method,
+		KeyFormat:         attrs["KEYFORMAT"],
+		KeyFormatVersions: attrs["KEYFORMATVERSIONS"],
+	}
+	if key.KeyFormat == "" {
+		key.KeyFormat = "identity" // 规范规定的默认值
+	}
+
+	// 密钥URI同样可能是相对路径
+	if uri, ok := attrs["URI"]; ok {
+		resolved, err := p.parseRelativeURL(uri, baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("解析密钥 URI 失败: %w", err)
+		}
+		key.URI = resolved
+	}
+
+	// IV 是0x开头的128位十六进制数
+	if ivStr, ok := attrs["IV"]; ok {
+		iv, err := parseIV(ivStr)
+		if err != nil {
+			return nil, err
+		}
+		key.IV = iv
 	}
 
-	return urls, nil
+	return key, nil
+}
+
+// parseMap 解析 EXT-X-MAP 的属性列表
+func (p *M3U8Parser) parseMap(value string, baseURL *url.URL) (*Map, error) {
+	attrs := ParseAttributes(value)
+
+	uri, ok := attrs["URI"]
+	if !ok {
+		return nil, fmt.Errorf("缺少 URI 属性")
+	}
+	resolved, err := p.parseRelativeURL(uri, baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("解析初始化片段 URI 失败: %w", err)
+	}
+
+	segMap := &Map{URI: resolved}
+	if rangeStr, ok := attrs["BYTERANGE"]; ok {
+		byteRange, err := parseByteRange(rangeStr)
+		if err != nil {
+			return nil, err
+		}
+		if byteRange.Offset < 0 {
+			byteRange.Offset = 0 // EXT-X-MAP 的偏移省略时从资源开头开始
+		}
+		segMap.ByteRange = byteRange
+	}
+
+	return segMap, nil
+}
+
+// parseMedia 解析 EXT-X-MEDIA 的属性列表
+func (p *M3U8Parser) parseMedia(value string, baseURL *url.URL) (*Rendition, error) {
+	attrs := ParseAttributes(value)
+
+	rendition := &Rendition{
+		Type:            strings.ToUpper(attrs["TYPE"]),
+		GroupID:         attrs["GROUP-ID"],
+		Language:        attrs["LANGUAGE"],
+		Name:            attrs["NAME"],
+		Default:         attrs["DEFAULT"] == "YES",
+		Autoselect:      attrs["AUTOSELECT"] == "YES",
+		Forced:          attrs["FORCED"] == "YES",
+		Characteristics: attrs["CHARACTERISTICS"],
+		Channels:        attrs["CHANNELS"],
+		InstreamID:      attrs["INSTREAM-ID"],
+	}
+	if rendition.Type == "" || rendition.GroupID == "" {
+		return nil, fmt.Errorf("缺少 TYPE 或 GROUP-ID 属性")
+	}
+
+	if uri, ok := attrs["URI"]; ok {
+		resolved, err := p.parseRelativeURL(uri, baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("解析渲染 URI 失败: %w", err)
+		}
+		rendition.URI = resolved
+	}
+
+	return rendition, nil
+}
+
+// parseStreamInf 解析 EXT-X-STREAM-INF 的属性列表，URI 由下一行补上
+func parseStreamInf(value string) *Variant {
+	attrs := ParseAttributes(value)
+
+	variant := &Variant{
+		HDCPLevel:      attrs["HDCP-LEVEL"],
+		VideoRange:     attrs["VIDEO-RANGE"],
+		Audio:          attrs["AUDIO"],
+		Subtitles:      attrs["SUBTITLES"],
+		ClosedCaptions: attrs["CLOSED-CAPTIONS"],
+	}
+	variant.Bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+	variant.AverageBandwidth, _ = strconv.Atoi(attrs["AVERAGE-BANDWIDTH"])
+	variant.FrameRate, _ = strconv.ParseFloat(attrs["FRAME-RATE"], 64)
+
+	// RESOLUTION 格式为 <宽>x<高>
+	if w, h, ok := strings.Cut(attrs["RESOLUTION"], "x"); ok {
+		variant.Width, _ = strconv.Atoi(w)
+		variant.Height, _ = strconv.Atoi(h)
+	}
+
+	// CODECS 是逗号分隔的列表
+	for _, codec := range strings.Split(attrs["CODECS"], ",") {
+		if codec = strings.TrimSpace(codec); codec != "" {
+			variant.Codecs = append(variant.Codecs, codec)
+		}
+	}
+
+	return variant
+}
+
+// parseByteRange 解析 <n>[@<o>] 格式的字节范围，省略偏移时 Offset 为 -1
+func parseByteRange(value string) (*ByteRange, error) {
+	lengthStr, offsetStr, hasOffset := strings.Cut(strings.TrimSpace(value), "@")
+
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil || length <= 0 {
+		return nil, fmt.Errorf("无效的字节范围长度: %s", lengthStr)
+	}
+
+	offset := int64(-1)
+	if hasOffset {
+		offset, err = strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("无效的字节范围偏移: %s", offsetStr)
+		}
+	}
+
+	return &ByteRange{Length: length, Offset: offset}, nil
+}
+
+// parseIV 解析 0x 开头的十六进制IV，不足16字节时在前面补零
+func parseIV(value string) ([]byte, error) {
+	hexStr := strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+	if len(hexStr) == 0 || len(hexStr) > 32 {
+		return nil, fmt.Errorf("无效的 IV: %s", value)
+	}
+	if len(hexStr)%2 == 1 {
+		hexStr = "0" + hexStr
+	}
+
+	raw, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 IV: %s", value)
+	}
+
+	iv := make([]byte, 16)
+	copy(iv[16-len(raw):], raw)
+	return iv, nil
+}
+
+// parseDateTime 解析 EXT-X-PROGRAM-DATE-TIME 的 ISO 8601 时间
+func parseDateTime(value string) (time.Time, error) {
+	// 部分服务器使用 +0800 这样不带冒号的时区，依次尝试
+	layouts := []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999Z0700"}
+	var lastErr error
+	for _, layout := range layouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+		lastErr = err
+	}
+	return time.Time{}, lastErr
 }
 
 // parseRelativeURL 解析相对URL为绝对URL
@@ -141,7 +530,13 @@ func (p *M3U8Parser) parseRelativeURL(relativeURL string, baseURL *url.URL) (str
 	return finalURL.String(), nil
 }
 
-// ExtractSegmentID 生成唯一的片段标识符
+// ID 按不连续序列号和媒体序列号生成片段的唯一标识，例如 "0:1234"；
+// 与片段的文件名无关，适用于按哈希命名、计数放在查询参数中或跨不连续点复用文件名的CDN
+func (s Segment) ID() string {
+	return fmt.Sprintf("%d:%d", s.DiscontinuitySequence, s.MediaSequence)
+}
+
+// ExtractSegmentID 按文件名末尾的数字生成片段标识符，没有数字时使用"媒体序列号_索引"
 func (p *M3U8Parser) ExtractSegmentID(urlStr string, mediaSeq, index int) (string, error) {
 	// 解析URL字符串
 	parsedURL, err := url.Parse(urlStr)
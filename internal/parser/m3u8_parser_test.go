@@ -0,0 +1,305 @@
+package parser
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testBaseURL = "https://example.com/live/index.m3u8"
+
+func mustParse(t *testing.T, content string) *Playlist {
+	t.Helper()
+	playlist, err := NewM3U8Parser().Parse(content, testBaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return playlist
+}
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   ByteRange
+		hasErr bool
+	}{
+		{value: "1000@200", want: ByteRange{Length: 1000, Offset: 200}},
+		{value: "1000@0", want: ByteRange{Length: 1000, Offset: 0}},
+		{value: "1000", want: ByteRange{Length: 1000, Offset: -1}}, // 偏移由上一个片段推算
+		{value: " 75232 ", want: ByteRange{Length: 75232, Offset: -1}},
+		{value: "0", hasErr: true},
+		{value: "-5", hasErr: true},
+		{value: "1000@-1", hasErr: true},
+		{value: "1000@x", hasErr: true},
+		{value: "", hasErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteRange(tt.value)
+		if tt.hasErr {
+			if err == nil {
+				t.Errorf("parseByteRange(%q) = %+v, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || *got != tt.want {
+			t.Errorf("parseByteRange(%q) = %+v, %v, want %+v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+// 省略偏移的字节范围从同一资源上一个范围的末尾继续，换了资源或第一个片段从0开始
+func TestParseByteRangeImpliedOffset(t *testing.T) {
+	playlist := mustParse(t, `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXTINF:4,
+#EXT-X-BYTERANGE:1000
+main.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:500
+main.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:300@2000
+main.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:700
+main.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:400
+other.ts
+#EXTINF:4,
+whole.ts
+`)
+	want := []*ByteRange{
+		{Length: 1000, Offset: 0},
+		{Length: 500, Offset: 1000},
+		{Length: 300, Offset: 2000},
+		{Length: 700, Offset: 2300},
+		{Length: 400, Offset: 0},
+		nil,
+	}
+	if len(playlist.Segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(playlist.Segments), len(want))
+	}
+	for i, seg := range playlist.Segments {
+		switch got := seg.ByteRange; {
+		case want[i] == nil && got != nil:
+			t.Errorf("segment %d: byte range %+v, want none", i, *got)
+		case want[i] != nil && (got == nil || *got != *want[i]):
+			t.Errorf("segment %d: byte range %+v, want %+v", i, got, *want[i])
+		}
+	}
+}
+
+// EXT-X-KEY 持续作用于之后的片段，METHOD=NONE 清除当前密钥
+func TestParseKeyScope(t *testing.T) {
+	playlist := mustParse(t, `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXTINF:4,
+clear0.ts
+#EXT-X-KEY:METHOD=AES-128,URI="keys/k1.bin",IV=0x1A2B
+#EXTINF:4,
+enc1.ts
+#EXTINF:4,
+enc2.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:4,
+clear3.ts
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="https://keys.example.com/k2",KEYFORMAT="identity",KEYFORMATVERSIONS="1"
+#EXTINF:4,
+enc4.ts
+`)
+	segs := playlist.Segments
+	if len(segs) != 5 {
+		t.Fatalf("got %d segments, want 5", len(segs))
+	}
+	if segs[0].Key != nil || segs[3].Key != nil {
+		t.Errorf("unencrypted segments have keys: %+v, %+v", segs[0].Key, segs[3].Key)
+	}
+	for _, i := range []int{1, 2} {
+		key := segs[i].Key
+		if key == nil || key.Method != "AES-128" || key.URI != "https://example.com/live/keys/k1.bin" || key.KeyFormat != "identity" {
+			t.Errorf("segment %d: key %+v", i, key)
+		}
+	}
+	if key := segs[4].Key; key == nil || key.Method != "SAMPLE-AES" || key.URI != "https://keys.example.com/k2" || key.IV != nil || key.KeyFormatVersions != "1" {
+		t.Errorf("segment 4: key %+v", key)
+	}
+}
+
+func TestParseIV(t *testing.T) {
+	full := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	tests := []struct {
+		value  string
+		want   []byte
+		hasErr bool
+	}{
+		{value: "0x000102030405060708090A0B0C0D0E0F", want: full},
+		{value: "0X000102030405060708090a0b0c0d0e0f", want: full},
+		{value: "0x1", want: append(make([]byte, 15), 1)},             // 奇数位补零
+		{value: "0x1A2B", want: append(make([]byte, 14), 0x1a, 0x2b)}, // 不足16字节在前面补零
+		{value: "0x00000000000000000000000000000007", want: append(make([]byte, 15), 7)},
+		{value: "0x", hasErr: true},
+		{value: "0x000102030405060708090A0B0C0D0E0F10", hasErr: true}, // 超过16字节
+		{value: "0xZZ", hasErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseIV(tt.value)
+		if tt.hasErr {
+			if err == nil {
+				t.Errorf("parseIV(%q) = %x, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("parseIV(%q) = %x, %v, want %x", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+// 每经过一个 EXT-X-DISCONTINUITY 不连续序列号加一，媒体序列号从 EXT-X-MEDIA-SEQUENCE 开始
+func TestParseDiscontinuitySequence(t *testing.T) {
+	playlist := mustParse(t, `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-DISCONTINUITY-SEQUENCE:5
+#EXTINF:4,
+a.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:4,
+b.ts
+#EXTINF:4,
+c.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:4,
+d.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:4,
+e.ts
+`)
+	if playlist.MediaSequence != 100 || playlist.DiscontinuitySequence != 5 {
+		t.Errorf("playlist sequences %d/%d, want 100/5", playlist.MediaSequence, playlist.DiscontinuitySequence)
+	}
+	want := []struct {
+		seq, disc     int
+		discontinuity bool
+	}{
+		{100, 5, false}, {101, 6, true}, {102, 6, false}, {103, 7, true}, {104, 8, true},
+	}
+	for i, seg := range playlist.Segments {
+		if seg.MediaSequence != want[i].seq || seg.DiscontinuitySequence != want[i].disc || seg.Discontinuity != want[i].discontinuity {
+			t.Errorf("segment %d: seq %d disc %d discontinuity %v, want %+v",
+				i, seg.MediaSequence, seg.DiscontinuitySequence, seg.Discontinuity, want[i])
+		}
+	}
+}
+
+// 没有 EXT-X-PROGRAM-DATE-TIME 的片段按上一个片段的时间加时长推算，不跨越不连续点
+func TestParseProgramDateTimeCarriedForward(t *testing.T) {
+	playlist := mustParse(t, `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-PROGRAM-DATE-TIME:2024-03-01T12:00:00.000Z
+#EXTINF:4.5,
+a.ts
+#EXTINF:6,
+b.ts
+#EXTINF:6,
+c.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:6,
+d.ts
+#EXT-X-PROGRAM-DATE-TIME:2024-03-01T13:00:00+08:00
+#EXTINF:6,
+e.ts
+#EXTINF:6,
+f.ts
+`)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	restart := time.Date(2024, 3, 1, 5, 0, 0, 0, time.UTC)
+	want := []time.Time{
+		start,
+		start.Add(4500 * time.Millisecond),
+		start.Add(10500 * time.Millisecond),
+		{}, // 不连续点之后时间未知
+		restart,
+		restart.Add(6 * time.Second),
+	}
+	for i, seg := range playlist.Segments {
+		if !seg.ProgramDateTime.Equal(want[i]) {
+			t.Errorf("segment %d: program date time %v, want %v", i, seg.ProgramDateTime, want[i])
+		}
+	}
+}
+
+func TestParseDateTime(t *testing.T) {
+	want := time.Date(2024, 3, 1, 4, 5, 6, 789000000, time.UTC)
+	for _, value := range []string{
+		"2024-03-01T04:05:06.789Z",
+		"2024-03-01T12:05:06.789+08:00",
+		"2024-03-01T12:05:06.789+0800",
+	} {
+		got, err := parseDateTime(value)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseDateTime(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if _, err := parseDateTime("2024-03-01 04:05:06"); err == nil {
+		t.Error("parseDateTime accepted a time without T and zone")
+	}
+}
+
+func TestParseMasterPlaylist(t *testing.T) {
+	playlist := mustParse(t, `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",LANGUAGE="en",NAME="English, stereo",DEFAULT=YES,AUTOSELECT=YES,CHANNELS="2",URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",LANGUAGE="zh-Hans",NAME="中文",FORCED=YES,CHARACTERISTICS="public.accessibility.transcribes-spoken-dialog",URI="https://cdn.example.com/subs/zh.m3u8"
+#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID="cc",NAME="CC1",INSTREAM-ID="CC1"
+#EXT-X-STREAM-INF:BANDWIDTH=2560000,AVERAGE-BANDWIDTH=2000000,RESOLUTION=1280x720,CODECS="avc1.64001f,mp4a.40.2",FRAME-RATE=29.970,HDCP-LEVEL=NONE,VIDEO-RANGE=SDR,AUDIO="aud",SUBTITLES="subs",CLOSED-CAPTIONS="cc"
+720p/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=640000,CLOSED-CAPTIONS=NONE
+/low/index.m3u8
+`)
+	if !playlist.IsMaster {
+		t.Fatal("master playlist not detected")
+	}
+
+	if len(playlist.Variants) != 2 {
+		t.Fatalf("got %d variants, want 2", len(playlist.Variants))
+	}
+	hd := playlist.Variants[0]
+	if hd.URI != "https://example.com/live/720p/index.m3u8" || hd.Bandwidth != 2560000 || hd.AverageBandwidth != 2000000 ||
+		hd.Width != 1280 || hd.Height != 720 || hd.FrameRate != 29.97 || hd.HDCPLevel != "NONE" || hd.VideoRange != "SDR" ||
+		hd.Audio != "aud" || hd.Subtitles != "subs" || hd.ClosedCaptions != "cc" {
+		t.Errorf("variant 0: %+v", hd)
+	}
+	if !slices.Equal(hd.Codecs, []string{"avc1.64001f", "mp4a.40.2"}) {
+		t.Errorf("variant 0 codecs %q", hd.Codecs)
+	}
+	low := playlist.Variants[1]
+	if low.URI != "https://example.com/low/index.m3u8" || low.Bandwidth != 640000 || low.Width != 0 || low.Codecs != nil || low.ClosedCaptions != "NONE" {
+		t.Errorf("variant 1: %+v", low)
+	}
+
+	want := []Rendition{
+		{Type: "AUDIO", GroupID: "aud", URI: "https://example.com/live/audio/en.m3u8", Language: "en", Name: "English, stereo",
+			Default: true, Autoselect: true, Channels: "2"},
+		{Type: "SUBTITLES", GroupID: "subs", URI: "https://cdn.example.com/subs/zh.m3u8", Language: "zh-Hans", Name: "中文",
+			Forced: true, Characteristics: "public.accessibility.transcribes-spoken-dialog"},
+		{Type: "CLOSED-CAPTIONS", GroupID: "cc", Name: "CC1", InstreamID: "CC1"},
+	}
+	if !slices.Equal(playlist.Renditions, want) {
+		t.Errorf("renditions:\n got %+v\nwant %+v", playlist.Renditions, want)
+	}
+}
+
+// 缺少 TYPE 或 GROUP-ID 的 EXT-X-MEDIA 被跳过
+func TestParseMediaRequiresTypeAndGroup(t *testing.T) {
+	playlist := mustParse(t, `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,NAME="no group"
+#EXT-X-MEDIA:GROUP-ID="aud",NAME="no type"
+#EXT-X-STREAM-INF:BANDWIDTH=1
+a.m3u8
+`)
+	if len(playlist.Renditions) != 0 {
+		t.Errorf("got renditions %+v, want none", playlist.Renditions)
+	}
+}
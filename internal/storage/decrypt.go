@@ -0,0 +1,99 @@
+package storage  // 存储包，负责文件的下载和存储管理
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+)
+
+// saveDecrypted 解密片段并写入文件，按选项同时保留加密原文件
+func (fm *FileManager) saveDecrypted(task SegmentTask, data []byte, result *DownloadResult) error {
+	decryption := task.Decryption
+	filepath := result.Filename
+
+	// 归档模式：先原样保存密文
+	if fm.options.KeepEncrypted {
+		if err := writeFileAtomic(filepath+".enc", data, 0644); err != nil {
+			return fmt.Errorf("保存加密原文件失败: %w", err)
+		}
+	}
+
+	var plain []byte
+	var err error
+	switch decryption.Method {
+	case "AES-128":
+		plain, err = decryptAES128(data, decryption.Key, decryption.IV)
+	case "SAMPLE-AES":
+		plain, err = decryptSampleAESTS(data, decryption.Key, decryption.IV)
+	default:
+		err = fmt.Errorf("不支持的加密方式: %s", decryption.Method)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 需要独立播放时，先写入初始化片段
+	if len(task.InitData) > 0 {
+		plain = append(append([]byte(nil), task.InitData...), plain...)
+	}
+	if err := writeFileAtomic(filepath, plain, 0644); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(plain)
+	result.Size = int64(len(plain))
+	result.Checksum = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// decryptAES128 使用 AES-128-CBC 解密整个片段，并去掉 PKCS#7 填充
+func decryptAES128(data, key, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("创建 AES 解密器失败: %w", err)
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("密文长度 %d 不是 %d 的整数倍", len(data), aes.BlockSize)
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// 去掉 PKCS#7 填充：最后一个字节就是填充长度
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(plain) ||
+		!bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("无效的 PKCS#7 填充，密钥或IV可能不正确")
+	}
+
+	return plain[:len(plain)-padding], nil
+}
+
+// SaveKey 将密钥保存到输出目录的 keys 子目录，文件名由密钥URI的哈希生成
+func (fm *FileManager) SaveKey(outputDir, keyURI string, key []byte) (string, error) {
+	keyDir := path.Join(outputDir, "keys")
+	if err := os.MkdirAll(keyDir, 0755); err != nil {
+		return "", fmt.Errorf("创建密钥目录失败: %w", err)
+	}
+
+	// 用URI哈希作为文件名，避免URI中的特殊字符，同一密钥只保存一份
+	sum := sha1.Sum([]byte(keyURI))
+	keyPath := path.Join(keyDir, hex.EncodeToString(sum[:8])+".key")
+
+	// 密钥文件只允许所有者读写
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		return "", fmt.Errorf("写入密钥文件失败: %w", err)
+	}
+	// 记录密钥来源，方便归档时对应回播放列表
+	if err := os.WriteFile(keyPath+".uri", []byte(keyURI+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("写入密钥来源失败: %w", err)
+	}
+
+	return keyPath, nil
+}
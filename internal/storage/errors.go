@@ -0,0 +1,132 @@
+package storage  // 存储包，负责文件的下载和存储管理
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/MGter/hls_downloader/pkg/utils"
+)
+
+// ErrorClass 下载失败原因的分类，用于区分个别片段抖动和源站整体不可用
+type ErrorClass string
+
+const (
+	ClassHTTPClient ErrorClass = "http_4xx"   // 服务器返回 4xx 状态码
+	ClassHTTPServer ErrorClass = "http_5xx"   // 服务器返回 5xx 状态码
+	ClassHTTPOther  ErrorClass = "http_other" // 其它非预期的状态码
+	ClassTimeout    ErrorClass = "timeout"    // 连接或读取超时
+	ClassNetwork    ErrorClass = "network"    // DNS、连接被拒绝、连接重置等网络错误
+	ClassIncomplete ErrorClass = "incomplete" // 响应体提前结束或长度不符
+	ClassFile       ErrorClass = "file"       // 本地文件读写失败
+	ClassCanceled   ErrorClass = "canceled"   // 下载被取消
+	ClassOther      ErrorClass = "other"      // 无法归类的错误
+)
+
+// SegmentError 单个片段重试用尽后的失败详情
+type SegmentError struct {
+	URL           string     // 片段地址
+	Sequence      int        // 媒体序列号
+	Attempts      int        // 实际发出的请求次数
+	StatusCode    int        // 最后一次收到的HTTP状态码，0 表示没有收到响应
+	BytesReceived int64      // 所有尝试累计收到的响应体字节数
+	Class         ErrorClass // 最后一次失败的分类
+	Err           error      // 最后一次失败的原因
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("下载失败 [%s]: 尝试 %d 次, 状态码 %d, 已接收 %d 字节, 类型 %s: %v",
+		e.URL, e.Attempts, e.StatusCode, e.BytesReceived, e.Class, e.Err)
+}
+
+func (e *SegmentError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError 一批并发下载中所有失败片段的汇总
+type BatchError struct {
+	Total    int             // 本批片段总数
+	Failures []*SegmentError // 失败的片段，按任务顺序排列
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d/%d 个片段下载失败 (%s)", len(e.Failures), e.Total, e.classSummary())
+}
+
+// Unwrap 返回每个片段的错误，便于 errors.Is / errors.As 逐个检查
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f
+	}
+	return errs
+}
+
+// ByClass 按失败分类统计片段数
+func (e *BatchError) ByClass() map[ErrorClass]int {
+	counts := make(map[ErrorClass]int)
+	for _, f := range e.Failures {
+		counts[f.Class]++
+	}
+	return counts
+}
+
+// classSummary 把分类统计格式化为 "http_4xx: 2, timeout: 1"
+func (e *BatchError) classSummary() string {
+	counts := e.ByClass()
+	classes := make([]string, 0, len(counts))
+	for class := range counts {
+		classes = append(classes, string(class))
+	}
+	sort.Strings(classes)
+
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = fmt.Sprintf("%s: %d", class, counts[ErrorClass(class)])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ClassifyError 判断下载错误的分类
+func ClassifyError(err error) ErrorClass {
+	var statusErr *utils.HTTPStatusError
+	var netErr net.Error
+	var pathErr *fs.PathError
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case errors.As(err, &statusErr):
+		switch {
+		case statusErr.StatusCode >= 500:
+			return ClassHTTPServer
+		case statusErr.StatusCode >= 400:
+			return ClassHTTPClient
+		default:
+			return ClassHTTPOther
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		return ClassTimeout
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ClassTimeout
+		}
+		return ClassNetwork
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, errIncomplete):
+		return ClassIncomplete
+	case errors.As(err, &pathErr):
+		return ClassFile
+	default:
+		return ClassOther
+	}
+}
+
+// errIncomplete 字节范围片段收到的数据长度不符，和响应体中断一样可以重试
+var errIncomplete = fmt.Errorf("字节范围不完整: %w", io.ErrUnexpectedEOF)
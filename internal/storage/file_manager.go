@@ -2,6 +2,9 @@ package storage  // 存储包，负责文件的下载和存储管理
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,78 +14,185 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/MGter/hls_downloader/pkg/logger"
+	"github.com/MGter/hls_downloader/pkg/utils"
 )
 
 // FileManager 文件管理器结构体
 type FileManager struct {
-	mu sync.RWMutex  // 读写锁，用于保护并发访问
+	mu      sync.RWMutex  // 读写锁，用于保护并发访问
+	options Options       // 存储选项
+	client  *utils.Client // 下载片段使用的HTTP客户端
+}
+
+// Options 文件管理器选项
+type Options struct {
+	KeepEncrypted bool          // 解密后仍保留加密原文件（.enc），用于归档
+	Client        *utils.Client // 下载片段使用的HTTP客户端，nil 时使用默认设置创建
+}
+
+// DownloadHooks 片段开始下载和下载结束时的回调，在各自的下载协程中调用，未设置的回调会被跳过
+type DownloadHooks struct {
+	Started  func(index int, filename string)        // 已确定保存路径、即将发出请求
+	Finished func(index int, result *DownloadResult) // 下载成功或重试用尽后失败，result.Err 为 nil 表示成功
+}
+
+// SegmentTask 单个片段的下载任务
+type SegmentTask struct {
+	URL        string      // 片段地址
+	Sequence   int         // 媒体序列号
+	ByteRange  *ByteRange  // 字节范围，nil 表示下载整个资源
+	Decryption *Decryption // 解密参数，nil 表示片段未加密
+	Fragmented bool        // 是否是 fMP4/CMAF 分片（有 EXT-X-MAP），影响默认扩展名
+	InitData   []byte      // 写在片段数据之前的初始化片段，用于生成可独立播放的文件
+}
+
+// DownloadResult 单个片段的下载结果
+type DownloadResult struct {
+	Task          SegmentTask // 对应的下载任务
+	Filename      string      // 保存路径
+	Size          int64       // 写入文件的字节数
+	Checksum      string      // 文件内容的 SHA-256（十六进制）
+	Attempts      int         // 实际发出的请求次数
+	StatusCode    int         // 最后一次收到的HTTP状态码
+	BytesReceived int64       // 所有尝试累计收到的响应体字节数
+	Err           error       // 下载失败的原因（*SegmentError），nil 表示成功
+}
+
+// mediaExtensions 可以直接沿用的媒体文件扩展名
+var mediaExtensions = map[string]bool{
+	".ts": true, ".m4s": true, ".mp4": true, ".m4a": true, ".m4v": true,
+	".cmfv": true, ".cmfa": true, ".aac": true, ".ac3": true, ".ec3": true,
+	".mp3": true, ".vtt": true, ".webvtt": true,
+}
+
+// ByteRange 资源中的一段字节
+type ByteRange struct {
+	Offset int64  // 起始偏移
+	Length int64  // 长度
+}
+
+// Decryption 片段的解密参数
+type Decryption struct {
+	Method string  // 加密方式：AES-128 或 SAMPLE-AES（仅 MPEG-TS）
+	Key    []byte  // 16字节密钥
+	IV     []byte  // 16字节IV
 }
 
 // NewFileManager 创建新的文件管理器
 func NewFileManager() *FileManager {
-	return &FileManager{}
+	return NewFileManagerWithOptions(Options{})
+}
+
+// NewFileManagerWithOptions 使用指定选项创建文件管理器
+func NewFileManagerWithOptions(options Options) *FileManager {
+	client := options.Client
+	if client == nil {
+		client = utils.NewClient(utils.DefaultClientOptions())
+	}
+	return &FileManager{options: options, client: client}
 }
 
-// ConcurrentDownload 并发下载多个文件
-func (fm *FileManager) ConcurrentDownload(ctx context.Context, urls []string, tempDir string, maxConcurrent, maxRetries int) error {
+// ConcurrentDownload 并发下载多个文件，返回与 tasks 一一对应的结果；
+// 有片段失败时返回 *BatchError，其中包含每个失败片段的详情
+func (fm *FileManager) ConcurrentDownload(ctx context.Context, tasks []SegmentTask, tempDir string, maxConcurrent int, policy utils.RetryPolicy, hooks DownloadHooks) ([]DownloadResult, error) {
 	var wg sync.WaitGroup          // 等待组，用于等待所有goroutine完成
 	sem := make(chan struct{}, maxConcurrent)  // 信号量，控制最大并发数
-	errChan := make(chan error, len(urls))     // 错误通道，收集下载错误
+	results := make([]DownloadResult, len(tasks))  // 每个任务的结果，各goroutine只写自己的位置
 
-	// 遍历所有要下载的URL
-	for i, fileURL := range urls {
+	// 遍历所有下载任务
+	for i, task := range tasks {
 		wg.Add(1)     // 等待组计数加1
 		sem <- struct{}{}  // 获取一个信号量，如果已满则等待
 
-		// 为每个URL启动一个goroutine进行下载
-		go func(index int, currentURL string) {
+		// 为每个任务启动一个goroutine进行下载
+		go func(index int, task SegmentTask) {
 			defer wg.Done()          // goroutine结束时减少等待组计数
 			defer func() { <-sem }() // 释放信号量，允许其他goroutine执行
 
+			result := &results[index]
+			result.Task = task
+			if hooks.Finished != nil {
+				defer hooks.Finished(index, result)
+			}
+
 			// 生成要保存的文件名
-			filename, err := fm.generateFilename(currentURL, tempDir, index)
+			filename, err := fm.generateFilename(task, tempDir, index)
 			if err != nil {
-				errChan <- fmt.Errorf("生成文件名失败 [%s]: %w", currentURL, err)
+				result.Err = newSegmentError(result, fmt.Errorf("生成文件名失败: %w", err))
 				return
 			}
+			result.Filename = filename
+			if hooks.Started != nil {
+				hooks.Started(index, filename)
+			}
 
 			// 下载文件（带重试机制）
-			if err := fm.downloadFileWithRetry(currentURL, filename, maxRetries); err != nil {
-				errChan <- fmt.Errorf("下载失败 [%s]: %w", currentURL, err)
+			if err := fm.downloadFileWithRetry(ctx, task, result, policy); err != nil {
+				result.Err = newSegmentError(result, err)
 				return
 			}
 
-			// 下载成功，打印信息
-			fmt.Printf("下载完成: %s", path.Base(filename))
-		}(i, fileURL)
+			logger.Debug.Printf("下载完成: %s\n", path.Base(filename))
+		}(i, task)
 	}
 
 	// 等待所有goroutine完成
 	wg.Wait()
-	close(errChan)  // 关闭错误通道
 
-	// 检查是否有错误发生
-	for err := range errChan {
-		return err  // 如果有错误，返回第一个错误
+	// 收集所有失败的片段，而不只是第一个
+	batch := &BatchError{Total: len(tasks)}
+	for i := range results {
+		var segErr *SegmentError
+		if errors.As(results[i].Err, &segErr) {
+			batch.Failures = append(batch.Failures, segErr)
+		}
+	}
+	if len(batch.Failures) > 0 {
+		return results, batch
 	}
 
-	return nil  // 所有下载都成功
+	return results, nil  // 所有下载都成功
+}
+
+// newSegmentError 根据下载结果生成失败详情
+func newSegmentError(result *DownloadResult, err error) *SegmentError {
+	return &SegmentError{
+		URL:           result.Task.URL,
+		Sequence:      result.Task.Sequence,
+		Attempts:      result.Attempts,
+		StatusCode:    result.StatusCode,
+		BytesReceived: result.BytesReceived,
+		Class:         ClassifyError(err),
+		Err:           err,
+	}
 }
 
 // generateFilename 生成唯一的文件名
-func (fm *FileManager) generateFilename(tsURL, tempDir string, index int) (string, error) {
+func (fm *FileManager) generateFilename(task SegmentTask, tempDir string, index int) (string, error) {
 	// 解析URL
-	parsedURL, err := url.Parse(tsURL)
+	parsedURL, err := url.Parse(task.URL)
 	if err != nil {
 		return "", err
 	}
 
 	// 从URL路径中获取基础文件名
 	baseFilename := path.Base(parsedURL.Path)
-	
-	// 确保文件扩展名为.ts
-	if !strings.HasSuffix(baseFilename, ".ts") {
-		baseFilename += ".ts"
+
+	// 同一个资源的不同字节范围需要区分文件名，例如 main_1000-1999.ts
+	if br := task.ByteRange; br != nil {
+		ext := path.Ext(baseFilename)
+		baseFilename = fmt.Sprintf("%s_%d-%d%s", strings.TrimSuffix(baseFilename, ext), br.Offset, br.Offset+br.Length-1, ext)
+	}
+
+	// 保留真实的媒体扩展名，无法识别时 fMP4 分片用 .m4s，其他用 .ts
+	if !mediaExtensions[strings.ToLower(path.Ext(baseFilename))] {
+		if task.Fragmented {
+			baseFilename += ".m4s"
+		} else {
+			baseFilename += ".ts"
+		}
 	}
 
 	// 生成唯一文件名：时间戳_索引号_原文件名
@@ -95,54 +205,203 @@ func (fm *FileManager) generateFilename(tsURL, tempDir string, index int) (strin
 	return path.Join(tempDir, uniqueFilename), nil
 }
 
-// downloadFileWithRetry 带重试机制的下载
-func (fm *FileManager) downloadFileWithRetry(fileURL, filepath string, maxRetries int) error {
-	// 尝试下载，最多重试maxRetries次
-	for i := 0; i < maxRetries; i++ {
-		// 尝试下载单个文件
-		if err := fm.downloadSingleFile(fileURL, filepath); err == nil {
-			return nil  // 下载成功
+// downloadFileWithRetry 按重试策略下载，尝试次数和最后的状态码记录在 result 中；
+// 404/403 等永久错误不再重试，429/503 遵循服务器的 Retry-After
+func (fm *FileManager) downloadFileWithRetry(ctx context.Context, task SegmentTask, result *DownloadResult, policy utils.RetryPolicy) error {
+	err := policy.Do(ctx, func(attempt int) error {
+		result.Attempts = attempt
+		return fm.downloadSingleFile(ctx, task, result)
+	})
+	if err == nil {
+		return nil  // 下载成功
+	}
+
+	// 下载被取消，不再重试
+	if ctx.Err() != nil {
+		return fmt.Errorf("下载已取消: %w（最后一次错误: %v）", ctx.Err(), err)
+	}
+	if !utils.Retryable(err) {
+		return fmt.Errorf("不可重试的错误: %w", err)
+	}
+	// 所有重试都失败，保留最后一次的原因用于分类
+	return fmt.Errorf("达到最大重试次数: %w", err)
+}
+
+// downloadSingleFile 下载单个文件，成功后在 result 中记录大小和校验和
+func (fm *FileManager) downloadSingleFile(ctx context.Context, task SegmentTask, result *DownloadResult) error {
+	filepath := result.Filename
+
+	// 发送HTTP GET请求（字节范围片段带 Range 头）
+	body, status, err := fm.openSegment(ctx, task)
+	if status != 0 {
+		result.StatusCode = status
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()  // 确保响应体关闭
+	body = countingReader{body, &result.BytesReceived}
+
+	// 检查文件是否已存在（避免重复下载）
+	if _, err := os.Stat(filepath); err == nil {
+		// 文件已存在，直接返回成功
+		result.Size, result.Checksum, err = checksumFile(filepath)
+		return err
+	}
+
+	// 加密片段需要完整读入内存后解密
+	if task.Decryption != nil {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return err
 		}
-		
-		// 如果不是最后一次重试，等待一段时间
-		if i < maxRetries-1 {
-			delay := time.Second * time.Duration(i+1)  // 重试延迟时间逐渐增加
-			time.Sleep(delay)
+		if br := task.ByteRange; br != nil && int64(len(data)) != br.Length {
+			return fmt.Errorf("%w: 期望 %d 字节，实际 %d 字节", errIncomplete, br.Length, len(data))
 		}
+		return fm.saveDecrypted(task, data, result)
 	}
-	// 所有重试都失败
-	return fmt.Errorf("达到最大重试次数: %s", fileURL)
-}
 
-// downloadSingleFile 下载单个文件
-func (fm *FileManager) downloadSingleFile(fileURL, filepath string) error {
-	// 发送HTTP GET请求
-	resp, err := http.Get(fileURL)
+	// 先写入临时文件，完整下载后再改名，中断时不会留下半个片段
+	partPath := filepath + ".part"
+	out, err := os.Create(partPath)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()  // 确保响应体关闭
 
-	// 检查HTTP状态码是否为200 OK
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP状态码: %d", resp.StatusCode)
+	// 需要独立播放时，先写入初始化片段，再将HTTP响应体复制到文件中，同时计算校验和
+	hash := sha256.New()
+	writer := io.MultiWriter(out, hash)
+	n := int64(0)
+	if _, err = writer.Write(task.InitData); err == nil {
+		n, err = io.Copy(writer, body)
+	}
+	// 字节范围片段需要校验长度，不完整的文件删除后重试
+	if br := task.ByteRange; err == nil && br != nil && n != br.Length {
+		err = fmt.Errorf("%w: 期望 %d 字节，实际 %d 字节", errIncomplete, br.Length, n)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return err
 	}
 
-	// 检查文件是否已存在（避免重复下载）
-	if _, err := os.Stat(filepath); err == nil {
-		return nil  // 文件已存在，直接返回成功
+	result.Size = int64(len(task.InitData)) + n
+	result.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return os.Rename(partPath, filepath)
+}
+
+// checksumFile 计算已有文件的大小和 SHA-256
+func checksumFile(filepath string) (int64, string, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return 0, "", err
 	}
+	defer f.Close()
 
-	// 创建新文件
-	out, err := os.Create(filepath)
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
 	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeFileAtomic 先写临时文件再改名，保证目标文件要么完整要么不存在
+func writeFileAtomic(filepath string, data []byte, perm os.FileMode) error {
+	partPath := filepath + ".part"
+	if err := os.WriteFile(partPath, data, perm); err != nil {
+		os.Remove(partPath)
 		return err
 	}
-	defer out.Close()  // 确保文件关闭
+	return os.Rename(partPath, filepath)
+}
+
+// openSegment 发送片段请求并返回只包含片段数据的响应体和HTTP状态码
+func (fm *FileManager) openSegment(ctx context.Context, task SegmentTask) (io.ReadCloser, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, task.URL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	br := task.ByteRange
+	if br != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", br.Offset, br.Offset+br.Length-1))
+	}
+
+	resp, err := fm.client.Do(utils.KindSegment, req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && br != nil:
+		// 服务器按范围返回，限制长度以防返回多余数据
+		return readCloser{io.LimitReader(resp.Body, br.Length), resp.Body}, resp.StatusCode, nil
+	case resp.StatusCode == http.StatusOK && br != nil:
+		// 服务器忽略了 Range 头，返回了整个资源，跳过偏移前的数据
+		if _, err := io.CopyN(io.Discard, resp.Body, br.Offset); err != nil {
+			resp.Body.Close()
+			return nil, resp.StatusCode, fmt.Errorf("跳过字节范围偏移失败: %w", err)
+		}
+		return readCloser{io.LimitReader(resp.Body, br.Length), resp.Body}, resp.StatusCode, nil
+	case resp.StatusCode == http.StatusOK:
+		return resp.Body, resp.StatusCode, nil
+	default:
+		// 检查HTTP状态码是否为200 OK / 206 Partial Content
+		resp.Body.Close()
+		return nil, resp.StatusCode, utils.NewHTTPStatusError(resp)
+	}
+}
 
-	// 将HTTP响应体复制到文件中
-	_, err = io.Copy(out, resp.Body)
-	return err
+// readCloser 组合读取器和关闭器，用于截取响应体的一部分
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// countingReader 统计从响应体读取的字节数
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	*r.n += int64(n)
+	return n, err
+}
+
+// DownloadInitSegment 按重试策略下载 EXT-X-MAP 初始化片段并保存为 name，返回解密后的内容
+func (fm *FileManager) DownloadInitSegment(ctx context.Context, task SegmentTask, tempDir, name string, policy utils.RetryPolicy) ([]byte, error) {
+	var data []byte
+	err := policy.Do(ctx, func(int) error {
+		body, _, err := fm.openSegment(ctx, task)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		if data, err = io.ReadAll(body); err != nil {
+			return fmt.Errorf("读取初始化片段失败: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 初始化片段只会整体使用 AES-128 加密
+	if d := task.Decryption; d != nil && d.Method == "AES-128" {
+		if data, err = decryptAES128(data, d.Key, d.IV); err != nil {
+			return nil, fmt.Errorf("解密初始化片段失败: %w", err)
+		}
+	}
+
+	if err := writeFileAtomic(path.Join(tempDir, name), data, 0644); err != nil {
+		return nil, fmt.Errorf("保存初始化片段失败: %w", err)
+	}
+	return data, nil
 }
 
 // DeriveOutputDir 根据URL生成输出目录名
@@ -166,15 +425,20 @@ func (fm *FileManager) DeriveOutputDir(hlsURL string) (string, error) {
 	// 去除文件扩展名
 	baseName := strings.TrimSuffix(filename, path.Ext(filename))
 	
-	// 清理文件名，只保留安全字符（字母、数字、连字符、下划线）
-	safeName := strings.Map(func(r rune) rune {
+	// 清理文件名，只保留安全字符
+	safeName := SanitizeName(baseName)
+
+	// 返回目录名：清理后的名称_hls_segments
+	return fmt.Sprintf("%s_hls_segments", safeName), nil
+}
+
+// SanitizeName 清理名称，只保留安全字符（字母、数字、连字符、下划线）
+func SanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
 		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
 			(r >= '0' && r <= '9') || r == '-' || r == '_' {
 			return r  // 保留安全字符
 		}
 		return '_'  // 将不安全字符替换为下划线
-	}, baseName)
-
-	// 返回目录名：清理后的名称_hls_segments
-	return fmt.Sprintf("%s_hls_segments", safeName), nil
+	}, name)
 }
\ No newline at end of file
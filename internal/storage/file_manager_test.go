@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MGter/hls_downloader/pkg/utils"
+)
+
+// 初始化片段和媒体片段一样按重试策略重试，404 等永久错误不重试
+func TestDownloadInitSegmentRetries(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		switch {
+		case r.URL.Path == "/missing.mp4":
+			http.NotFound(w, r)
+		case n < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("ftyp-moov"))
+		}
+	}))
+	defer server.Close()
+
+	fm := NewFileManager()
+	dir := t.TempDir()
+	policy := utils.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	data, err := fm.DownloadInitSegment(context.Background(), SegmentTask{URL: server.URL + "/init.mp4"}, dir, "init_00000_init.mp4", policy)
+	if err != nil {
+		t.Fatalf("DownloadInitSegment: %v", err)
+	}
+	if string(data) != "ftyp-moov" || hits.Load() != 3 {
+		t.Errorf("got %q after %d requests, want ftyp-moov after 3", data, hits.Load())
+	}
+	if saved, err := os.ReadFile(filepath.Join(dir, "init_00000_init.mp4")); err != nil || string(saved) != "ftyp-moov" {
+		t.Errorf("saved file %q, err %v", saved, err)
+	}
+
+	hits.Store(0)
+	if _, err := fm.DownloadInitSegment(context.Background(), SegmentTask{URL: server.URL + "/missing.mp4"}, dir, "missing.mp4", policy); err == nil {
+		t.Error("404 init segment: want error")
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("404 init segment was requested %d times, want 1", n)
+	}
+}
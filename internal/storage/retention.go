@@ -0,0 +1,80 @@
+package storage  // 存储包，负责文件的下载和存储管理
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PruneOlderThan 删除保存目录（含渲染子目录）中修改时间早于 before 的片段文件，返回删除的文件数和字节数。
+// 状态文件、密钥目录、初始化片段和正在写入的 .part 文件不会被删除
+func PruneOlderThan(dir string, before time.Time) (removed int, freed int64, err error) {
+	err = filepath.WalkDir(dir, func(p string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			// 目录在清理过程中被删除不算错误
+			if errors.Is(walkErr, fs.ErrNotExist) {
+				return nil
+			}
+			return walkErr
+		}
+		if entry.IsDir() {
+			if entry.Name() == "keys" {
+				return filepath.SkipDir  // 密钥在片段删除后仍可能用于归档
+			}
+			return nil
+		}
+		if !prunable(entry.Name()) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	return removed, freed, err
+}
+
+// prunable 判断文件是否是可以按保留时间删除的片段
+func prunable(name string) bool {
+	switch {
+	case name == StateFileName:
+		return false
+	case strings.HasSuffix(name, ".part"):
+		return false
+	case strings.HasPrefix(name, "init_"):
+		return false  // 后续片段仍可能依赖同一个初始化片段
+	default:
+		return true
+	}
+}
+
+// DirSize 统计目录（含子目录）中所有文件的字节数，目录不存在时返回 0
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if errors.Is(walkErr, fs.ErrNotExist) {
+				return nil
+			}
+			return walkErr
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,755 @@
+package storage  // 存储包，负责文件的下载和存储管理
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+)
+
+// SAMPLE-AES 只加密 ES 中的部分数据（H.264 的 slice NAL、AAC/AC-3 音频帧），
+// TS 封装本身是明文。解密流程：按 PID 重组 PES -> 解密 ES -> 重新打包成 TS，
+// 同时把 PMT 中的加密流类型改回普通类型，输出可直接播放的明文 TS。
+// 重新打包的包按顺序放回原始包的位置，与其他 PID（音频、PCR、PSI）交错的顺序保持不变；
+// 解密后包数变多时，多出的包跟在最后一个原始包的位置之后，变少时多余的位置留空。
+
+const (
+	tsPacketSize = 188  // TS 包固定长度
+	tsSyncByte   = 0x47 // TS 包同步字节
+)
+
+// 加密流类型 -> 解密后的普通流类型
+var sampleAESStreamTypes = map[byte]byte{
+	0xdb: 0x1b, // H.264
+	0xcf: 0x0f, // AAC (ADTS)
+	0xc1: 0x81, // AC-3
+	0xc2: 0x87, // E-AC-3
+}
+
+// tsRewriter 逐包处理一个 TS 片段
+type tsRewriter struct {
+	block   cipher.Block          // AES 解密器
+	iv      []byte                // 每个 NAL/音频帧都从这个IV重新开始
+	pmtPIDs map[uint16]bool       // PAT 中声明的 PMT PID
+	streams map[uint16]byte       // 加密的 ES PID -> 加密流类型
+	pending map[uint16]*pesBuffer // 正在重组的 PES
+	pmts    map[uint16]*pmtBuffer // 正在重组的 PMT 节
+	cc      map[uint16]byte       // 重新打包的 PID 最后一个输出包的连续计数器
+	out     [][]byte              // 输出槽位，每个槽位是一个或多个 TS 包
+}
+
+// pesBuffer 正在重组的 PES
+type pesBuffer struct {
+	slots   []int          // 每个原始包在输出中的位置
+	cc      byte           // 第一个原始包的连续计数器
+	afs     [][]byte       // 每个带 payload 的原始包的调整字段（去掉填充），用于保留 PCR 等信息
+	afOnly  []afOnlyPacket // 夹在 PES 中间、只有调整字段的包
+	payload []byte         // 拼接后的 PES 数据
+}
+
+// afOnlyPacket 只有调整字段没有 payload 的包，例如单独携带 PCR 的包
+type afOnlyPacket struct {
+	after int    // 它之前有几个带 payload 的包，重新打包时放回这个位置
+	af    []byte // 调整字段（去掉填充）
+}
+
+// pmtBuffer 正在重组的 PMT 节，节较长时会跨多个 TS 包
+type pmtBuffer struct {
+	slots   []int    // 每个原始包在输出中的位置
+	cc      byte     // 第一个原始包的连续计数器
+	section []byte   // 拼接后的节数据
+	packets [][]byte // 原始包，节不需要改写或不完整时原样输出
+}
+
+// decryptSampleAESTS 解密 SAMPLE-AES 加密的 TS 片段
+func decryptSampleAESTS(data, key, iv []byte) ([]byte, error) {
+	if len(data)%tsPacketSize != 0 {
+		return nil, fmt.Errorf("TS 数据长度 %d 不是 %d 的整数倍", len(data), tsPacketSize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("创建 AES 解密器失败: %w", err)
+	}
+
+	rw := &tsRewriter{
+		block:   block,
+		iv:      iv,
+		pmtPIDs: make(map[uint16]bool),
+		streams: make(map[uint16]byte),
+		pending: make(map[uint16]*pesBuffer),
+		pmts:    make(map[uint16]*pmtBuffer),
+		cc:      make(map[uint16]byte),
+	}
+
+	for offset := 0; offset < len(data); offset += tsPacketSize {
+		pkt := data[offset : offset+tsPacketSize]
+		if pkt[0] != tsSyncByte {
+			return nil, fmt.Errorf("偏移 %d 处缺少 TS 同步字节", offset)
+		}
+		if err := rw.handlePacket(pkt); err != nil {
+			return nil, err
+		}
+	}
+
+	// 片段结束时输出剩余的 PES 和不完整的 PMT
+	for pid := range rw.pending {
+		if err := rw.flush(pid); err != nil {
+			return nil, err
+		}
+	}
+	for pid := range rw.pmts {
+		rw.flushPMT(pid, nil)
+	}
+
+	result := make([]byte, 0, len(data))
+	for _, packets := range rw.out {
+		result = append(result, packets...)
+	}
+	return result, nil
+}
+
+// handlePacket 处理单个 TS 包
+func (rw *tsRewriter) handlePacket(pkt []byte) error {
+	pid := binary.BigEndian.Uint16(pkt[1:3]) & 0x1fff
+	pusi := pkt[1]&0x40 != 0
+	af, payload := splitPacket(pkt)
+
+	switch {
+	case pid == 0 && pusi:
+		rw.parsePAT(payload)
+		rw.out = append(rw.out, clonePacket(pkt))
+	case rw.pmtPIDs[pid]:
+		rw.handlePMT(pid, pusi, pkt, payload)
+	case rw.streams[pid] != 0:
+		if pusi {
+			// 新的 PES 开始，先输出上一个
+			if err := rw.flush(pid); err != nil {
+				return err
+			}
+			rw.pending[pid] = &pesBuffer{cc: pkt[3] & 0x0f}
+		}
+		buf := rw.pending[pid]
+		if buf == nil {
+			// 片段开头不完整的 PES，无法解密，原样保留
+			rw.out = append(rw.out, rw.renumber(pid, pkt, payload != nil))
+			return nil
+		}
+		buf.slots = append(buf.slots, len(rw.out))
+		rw.out = append(rw.out, nil) // 占位，flush 时填充
+		if payload == nil {
+			buf.afOnly = append(buf.afOnly, afOnlyPacket{after: len(buf.afs), af: trimAdaptationField(af)})
+			return nil
+		}
+		buf.afs = append(buf.afs, trimAdaptationField(af))
+		buf.payload = append(buf.payload, payload...)
+	default:
+		rw.out = append(rw.out, clonePacket(pkt))
+	}
+	return nil
+}
+
+// flush 解密一个完整的 PES 并重新打包到原始包的输出槽位
+func (rw *tsRewriter) flush(pid uint16) error {
+	buf := rw.pending[pid]
+	if buf == nil {
+		return nil
+	}
+	delete(rw.pending, pid)
+
+	pes, err := rw.decryptPES(buf.payload, rw.streams[pid])
+	if err != nil {
+		return fmt.Errorf("解密 PID %d 的 PES 失败: %w", pid, err)
+	}
+
+	rw.place(buf.slots, rw.packetize(pid, pes, buf))
+	return nil
+}
+
+// place 把重新打包的包按顺序放回原始包的槽位，每个槽位一个包，多出的包都放在最后一个槽位
+func (rw *tsRewriter) place(slots []int, packets []byte) {
+	for i, slot := range slots {
+		n := min(tsPacketSize, len(packets))
+		if i == len(slots)-1 {
+			n = len(packets)
+		}
+		rw.out[slot] = packets[:n]
+		packets = packets[n:]
+	}
+}
+
+// nextCC 分配 pid 下一个输出包的连续计数器。解密和改写会改变包数，
+// 所以经过改写的 PID 从第一个包的原始值开始连续编号；只有调整字段的包不递增
+func (rw *tsRewriter) nextCC(pid uint16, original byte, hasPayload bool) byte {
+	last, ok := rw.cc[pid]
+	switch {
+	case !ok:
+		last = original
+	case hasPayload:
+		last = (last + 1) & 0x0f
+	}
+	rw.cc[pid] = last
+	return last
+}
+
+// renumber 复制原样输出的包，并按 nextCC 改写连续计数器
+func (rw *tsRewriter) renumber(pid uint16, pkt []byte, hasPayload bool) []byte {
+	out := clonePacket(pkt)
+	out[3] = out[3]&0xf0 | rw.nextCC(pid, pkt[3]&0x0f, hasPayload)
+	return out
+}
+
+// decryptPES 解密 PES 中的 ES 数据，并修正 PES_packet_length
+func (rw *tsRewriter) decryptPES(pes []byte, streamType byte) ([]byte, error) {
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return nil, fmt.Errorf("无效的 PES 起始码")
+	}
+	headerLen := 9 + int(pes[8])
+	if headerLen > len(pes) {
+		return nil, fmt.Errorf("PES 头长度 %d 超出数据长度 %d", headerLen, len(pes))
+	}
+
+	es := pes[headerLen:]
+	var clear []byte
+	switch streamType {
+	case 0xdb:
+		clear = rw.decryptH264(es)
+	case 0xcf:
+		clear = rw.decryptADTS(es)
+	case 0xc1, 0xc2:
+		clear = rw.decryptAC3(es)
+	default:
+		return pes, nil
+	}
+
+	result := make([]byte, 0, headerLen+len(clear))
+	result = append(result, pes[:headerLen]...)
+	result = append(result, clear...)
+
+	// 原来声明了长度的 PES 需要更新长度，视频 PES 通常为0（不限长度）
+	if binary.BigEndian.Uint16(pes[4:6]) != 0 {
+		length := len(result) - 6
+		if length > 0xffff {
+			length = 0
+		}
+		binary.BigEndian.PutUint16(result[4:6], uint16(length))
+	}
+	return result, nil
+}
+
+// decryptH264 解密 H.264 Annex B 字节流中的加密 NAL
+func (rw *tsRewriter) decryptH264(es []byte) []byte {
+	out := make([]byte, 0, len(es)+64)
+
+	starts := findStartCodes(es)
+	if len(starts) == 0 {
+		return es
+	}
+	out = append(out, es[:starts[0][0]]...) // 第一个起始码之前的数据原样保留
+
+	for i, sc := range starts {
+		end := len(es)
+		if i+1 < len(starts) {
+			end = starts[i+1][0]
+		}
+		out = append(out, es[sc[0]:sc[1]]...) // 起始码
+		out = append(out, rw.decryptNAL(es[sc[1]:end])...)
+	}
+	return out
+}
+
+// decryptNAL 解密单个 NAL：只有 slice（类型1、5）且长度超过48字节的才加密。
+// 去掉防竞争字节后，前32字节明文，之后每160字节中的前16字节是密文，
+// 同一个 NAL 内的密文块构成一条连续的 CBC 链，解密后重新插入防竞争字节。
+func (rw *tsRewriter) decryptNAL(nal []byte) []byte {
+	if len(nal) <= 48 {
+		return nal
+	}
+	if nalType := nal[0] & 0x1f; nalType != 1 && nalType != 5 {
+		return nal
+	}
+
+	raw := removeEmulationPrevention(nal)
+	mode := cipher.NewCBCDecrypter(rw.block, rw.iv)
+	for pos := 32; pos < len(raw)-16; pos += 160 {
+		mode.CryptBlocks(raw[pos:pos+16], raw[pos:pos+16])
+	}
+	return addEmulationPrevention(raw)
+}
+
+// decryptADTS 解密 ADTS 封装的 AAC 帧：帧头之后16字节明文，之后完整的16字节块为密文
+func (rw *tsRewriter) decryptADTS(es []byte) []byte {
+	out := append([]byte(nil), es...)
+
+	for offset := 0; offset+7 <= len(out); {
+		if out[offset] != 0xff || out[offset+1]&0xf0 != 0xf0 {
+			break // 不是 ADTS 帧头，剩余数据原样保留
+		}
+		headerLen := 7
+		if out[offset+1]&0x01 == 0 {
+			headerLen = 9 // protection_absent=0 时帧头带 CRC
+		}
+		frameLen := int(out[offset+3]&0x03)<<11 | int(out[offset+4])<<3 | int(out[offset+5])>>5
+		if frameLen < headerLen || offset+frameLen > len(out) {
+			break // 不完整的帧
+		}
+
+		rw.decryptAudioFrame(out[offset+headerLen : offset+frameLen])
+		offset += frameLen
+	}
+	return out
+}
+
+// decryptAC3 解密 AC-3 / E-AC-3 帧：整个同步帧的前16字节明文，之后完整的16字节块为密文
+func (rw *tsRewriter) decryptAC3(es []byte) []byte {
+	out := append([]byte(nil), es...)
+
+	for offset := 0; offset+6 <= len(out); {
+		if out[offset] != 0x0b || out[offset+1] != 0x77 {
+			break // 不是 AC-3 同步字，剩余数据原样保留
+		}
+		frameLen := ac3FrameSize(out[offset:])
+		if frameLen <= 0 || offset+frameLen > len(out) {
+			break // 无法识别或不完整的帧
+		}
+
+		rw.decryptAudioFrame(out[offset : offset+frameLen])
+		offset += frameLen
+	}
+	return out
+}
+
+// decryptAudioFrame 原地解密音频帧：跳过16字节明文前导，末尾不足16字节的部分保持明文
+func (rw *tsRewriter) decryptAudioFrame(frame []byte) {
+	if len(frame) <= 16 {
+		return
+	}
+	encrypted := frame[16 : len(frame)-len(frame)%16]
+	if len(encrypted) == 0 {
+		return
+	}
+	cipher.NewCBCDecrypter(rw.block, rw.iv).CryptBlocks(encrypted, encrypted)
+}
+
+// ac3FrameSize 根据帧头计算 AC-3 / E-AC-3 帧长度（字节），无法识别时返回0
+func ac3FrameSize(frame []byte) int {
+	bsid := frame[5] >> 3
+	if bsid > 10 {
+		// E-AC-3：frmsiz 以16位字为单位，减1存储
+		frmsiz := int(frame[2]&0x07)<<8 | int(frame[3])
+		return (frmsiz + 1) * 2
+	}
+
+	fscod := frame[4] >> 6
+	frmsizecod := frame[4] & 0x3f
+	if fscod > 2 || int(frmsizecod) >= len(ac3FrameSizes) {
+		return 0
+	}
+	return ac3FrameSizes[frmsizecod][fscod] * 2
+}
+
+// ac3FrameSizes AC-3 帧长度表（16位字），按 frmsizecod 和 fscod（48k/44.1k/32k）索引
+var ac3FrameSizes = [][3]int{
+	{64, 69, 96}, {64, 70, 96}, {80, 87, 120}, {80, 88, 120},
+	{96, 104, 144}, {96, 105, 144}, {112, 121, 168}, {112, 122, 168},
+	{128, 139, 192}, {128, 140, 192}, {160, 174, 240}, {160, 175, 240},
+	{192, 208, 288}, {192, 209, 288}, {224, 243, 336}, {224, 244, 336},
+	{256, 278, 384}, {256, 279, 384}, {320, 348, 480}, {320, 349, 480},
+	{384, 417, 576}, {384, 418, 576}, {448, 487, 672}, {448, 488, 672},
+	{512, 557, 768}, {512, 558, 768}, {640, 696, 960}, {640, 697, 960},
+	{768, 835, 1152}, {768, 836, 1152}, {896, 975, 1344}, {896, 976, 1344},
+	{1024, 1114, 1536}, {1024, 1115, 1536}, {1152, 1253, 1728}, {1152, 1254, 1728},
+	{1280, 1393, 1920}, {1280, 1394, 1920},
+}
+
+// parsePAT 从 PAT 中记录 PMT 的 PID
+func (rw *tsRewriter) parsePAT(payload []byte) {
+	section := psiSection(payload)
+	if section == nil || section[0] != 0x00 {
+		return
+	}
+	// 节头8字节，末尾4字节 CRC，中间每个节目4字节
+	for i := 8; i+4 <= len(section)-4; i += 4 {
+		programNumber := binary.BigEndian.Uint16(section[i : i+2])
+		pid := binary.BigEndian.Uint16(section[i+2:i+4]) & 0x1fff
+		if programNumber != 0 { // 节目号0对应 NIT
+			rw.pmtPIDs[pid] = true
+		}
+	}
+}
+
+// handlePMT 重组 PMT 节，完整后改写加密流类型并重新打包；节可能跨多个 TS 包
+func (rw *tsRewriter) handlePMT(pid uint16, pusi bool, pkt, payload []byte) {
+	buf := rw.pmts[pid]
+	switch {
+	case pusi && len(payload) > 0 && 1+int(payload[0]) <= len(payload):
+		rw.flushPMT(pid, nil) // 上一个节不完整，原样输出
+		buf = &pmtBuffer{cc: pkt[3] & 0x0f}
+		buf.section = append(buf.section, payload[1+int(payload[0]):]...)
+		rw.pmts[pid] = buf
+	case buf != nil && !pusi:
+		buf.section = append(buf.section, payload...)
+	default:
+		rw.flushPMT(pid, nil)
+		rw.out = append(rw.out, rw.renumber(pid, pkt, payload != nil))
+		return
+	}
+	buf.packets = append(buf.packets, clonePacket(pkt))
+	buf.slots = append(buf.slots, len(rw.out))
+	rw.out = append(rw.out, nil) // 占位，flushPMT 时填充
+
+	if len(buf.section) < 3 {
+		return
+	}
+	total := 3 + int(binary.BigEndian.Uint16(buf.section[1:3])&0x0fff)
+	if len(buf.section) < total {
+		return // 节还没有结束
+	}
+	rw.flushPMT(pid, rw.rewritePMT(buf.section[:total]))
+}
+
+// flushPMT 输出正在重组的 PMT：section 为改写后的节，为 nil 时原样输出原始包
+func (rw *tsRewriter) flushPMT(pid uint16, section []byte) {
+	buf := rw.pmts[pid]
+	if buf == nil {
+		return
+	}
+	delete(rw.pmts, pid)
+
+	if section == nil {
+		var out []byte
+		for _, pkt := range buf.packets {
+			_, payload := splitPacket(pkt)
+			out = append(out, rw.renumber(pid, pkt, payload != nil)...)
+		}
+		rw.place(buf.slots, out)
+		return
+	}
+
+	// 重新切分为 TS 包：pointer_field 为0，最后一个包剩余空间用 0xFF 填充
+	payload := append([]byte{0}, section...)
+	var out []byte
+	for first := true; len(payload) > 0; first = false {
+		pkt := make([]byte, tsPacketSize)
+		pkt[0] = tsSyncByte
+		pkt[1] = byte(pid>>8) & 0x1f
+		if first {
+			pkt[1] |= 0x40
+		}
+		pkt[2] = byte(pid)
+		pkt[3] = 0x10 | rw.nextCC(pid, buf.cc, true)
+		n := copy(pkt[4:], payload)
+		for i := 4 + n; i < len(pkt); i++ {
+			pkt[i] = 0xff
+		}
+		payload = payload[n:]
+		out = append(out, pkt...)
+	}
+	rw.place(buf.slots, out)
+}
+
+// rewritePMT 记录加密流的 PID，返回把加密流类型改回普通类型后的节；没有加密流或节无效时返回 nil
+func (rw *tsRewriter) rewritePMT(section []byte) []byte {
+	if section[0] != 0x02 || len(section) < 16 {
+		return nil
+	}
+
+	programInfoLen := int(binary.BigEndian.Uint16(section[10:12]) & 0x0fff)
+	if 12+programInfoLen > len(section)-4 {
+		return nil
+	}
+
+	rebuilt := append([]byte(nil), section[:12+programInfoLen]...)
+	changed := false
+	for i := 12 + programInfoLen; i+5 <= len(section)-4; {
+		streamType := section[i]
+		pid := binary.BigEndian.Uint16(section[i+1:i+3]) & 0x1fff
+		esInfoLen := int(binary.BigEndian.Uint16(section[i+3:i+5]) & 0x0fff)
+		if i+5+esInfoLen > len(section)-4 {
+			return nil // 损坏的 PMT，保持原样
+		}
+		descriptors := section[i+5 : i+5+esInfoLen]
+
+		entry := append([]byte(nil), section[i:i+5]...)
+		if clearType, ok := sampleAESStreamTypes[streamType]; ok {
+			rw.streams[pid] = streamType
+			entry[0] = clearType
+			descriptors = removeSampleAESDescriptors(descriptors)
+			binary.BigEndian.PutUint16(entry[3:5], 0xf000|uint16(len(descriptors)))
+			changed = true
+		}
+		rebuilt = append(rebuilt, entry...)
+		rebuilt = append(rebuilt, descriptors...)
+		i += 5 + esInfoLen
+	}
+	if !changed {
+		return nil
+	}
+
+	// 更新 section_length 并重新计算 CRC
+	sectionLen := len(rebuilt) + 4 - 3
+	rebuilt[1] = rebuilt[1]&0xf0 | byte(sectionLen>>8)&0x0f
+	rebuilt[2] = byte(sectionLen)
+	return binary.BigEndian.AppendUint32(rebuilt, crc32MPEG(rebuilt))
+}
+
+// removeSampleAESDescriptors 去掉 private_data_indicator 和 'apad' 注册描述符
+func removeSampleAESDescriptors(descriptors []byte) []byte {
+	var kept []byte
+	for i := 0; i+2 <= len(descriptors); {
+		tag, length := descriptors[i], int(descriptors[i+1])
+		if i+2+length > len(descriptors) {
+			break
+		}
+		d := descriptors[i : i+2+length]
+		i += 2 + length
+
+		if tag == 0x0f {
+			continue // private_data_indicator_descriptor：zavc / aacd / ac3d / ec3d
+		}
+		if tag == 0x05 && length >= 4 && string(d[2:6]) == "apad" {
+			continue // audio_setup_information 的注册描述符
+		}
+		kept = append(kept, d...)
+	}
+	return kept
+}
+
+// packetize 把 PES 重新切分为 TS 包，按顺序沿用原始包的调整字段，
+// 只有调整字段的包放回原来的相对位置
+func (rw *tsRewriter) packetize(pid uint16, pes []byte, buf *pesBuffer) []byte {
+	var out []byte
+	afOnly := buf.afOnly
+	i := 0
+	for ; len(pes) > 0 || i == 0; i++ {
+		for len(afOnly) > 0 && afOnly[0].after <= i {
+			out = append(out, rw.adaptationPacket(pid, afOnly[0].af)...)
+			afOnly = afOnly[1:]
+		}
+
+		var af []byte
+		if i < len(buf.afs) {
+			af = buf.afs[i]
+		}
+		pkt, consumed := buildPacket(pid, i == 0, rw.nextCC(pid, buf.cc, true), af, pes)
+		out = append(out, pkt...)
+		pes = pes[consumed:]
+	}
+
+	// 解密后包数变少时，多出来的调整字段可能带有 PCR 等信息，单独输出
+	for ; i < len(buf.afs); i++ {
+		if af := buf.afs[i]; len(af) > 0 && af[0] != 0 {
+			out = append(out, rw.adaptationPacket(pid, af)...)
+		}
+	}
+	for _, p := range afOnly {
+		out = append(out, rw.adaptationPacket(pid, p.af)...)
+	}
+	return out
+}
+
+// adaptationPacket 构造只有调整字段的包，调整字段用 0xFF 填满整个包
+func (rw *tsRewriter) adaptationPacket(pid uint16, af []byte) []byte {
+	pkt := make([]byte, tsPacketSize)
+	pkt[0] = tsSyncByte
+	pkt[1] = byte(pid>>8) & 0x1f
+	pkt[2] = byte(pid)
+	pkt[3] = 0x20 | rw.nextCC(pid, 0, false)
+	pkt[4] = tsPacketSize - 5
+	n := copy(pkt[5:], af)
+	for i := 5 + max(n, 1); i < len(pkt); i++ {
+		pkt[i] = 0xff
+	}
+	return pkt
+}
+
+// buildPacket 构造一个 TS 包，payload 不足时用调整字段填充，返回包和消耗的 payload 字节数
+func buildPacket(pid uint16, pusi bool, cc byte, af, payload []byte) ([]byte, int) {
+	pkt := make([]byte, 4, tsPacketSize)
+	pkt[0] = tsSyncByte
+	pkt[1] = byte(pid>>8) & 0x1f
+	if pusi {
+		pkt[1] |= 0x40
+	}
+	pkt[2] = byte(pid)
+
+	available := tsPacketSize - 4
+	if af != nil {
+		available -= 1 + len(af)
+	}
+
+	n := len(payload)
+	if n >= available {
+		n = available
+	} else {
+		// payload 不够填满整个包，需要用调整字段填充
+		stuffing := available - n
+		if af == nil {
+			if stuffing == 1 {
+				af = []byte{} // 只有长度字节（值为0）
+			} else {
+				af = make([]byte, stuffing-1)
+				af[0] = 0x00 // 标志位全0
+				for i := 1; i < len(af); i++ {
+					af[i] = 0xff
+				}
+			}
+		} else {
+			af = append(append([]byte(nil), af...), make([]byte, stuffing)...)
+			for i := len(af) - stuffing; i < len(af); i++ {
+				af[i] = 0xff
+			}
+		}
+	}
+
+	if af != nil {
+		pkt[3] = 0x30 | cc // 调整字段 + payload
+		pkt = append(pkt, byte(len(af)))
+		pkt = append(pkt, af...)
+	} else {
+		pkt[3] = 0x10 | cc // 只有 payload
+	}
+	pkt = append(pkt, payload[:n]...)
+	return pkt, n
+}
+
+// splitPacket 拆分 TS 包的调整字段（不含长度字节）和 payload
+func splitPacket(pkt []byte) (af, payload []byte) {
+	control := (pkt[3] >> 4) & 0x03
+	pos := 4
+	if control&0x02 != 0 {
+		afLen := int(pkt[4])
+		if 5+afLen > len(pkt) {
+			return nil, nil
+		}
+		af = pkt[5 : 5+afLen]
+		pos = 5 + afLen
+	}
+	if control&0x01 != 0 {
+		payload = pkt[pos:]
+	}
+	return af, payload
+}
+
+// trimAdaptationField 去掉调整字段末尾的填充字节，只保留标志位和可选字段
+func trimAdaptationField(af []byte) []byte {
+	if len(af) == 0 {
+		return nil
+	}
+	flags := af[0]
+	n := 1
+	if flags&0x10 != 0 {
+		n += 6 // PCR
+	}
+	if flags&0x08 != 0 {
+		n += 6 // OPCR
+	}
+	if flags&0x04 != 0 {
+		n++ // splice_countdown
+	}
+	if flags&0x02 != 0 && n < len(af) {
+		n += 1 + int(af[n]) // transport_private_data
+	}
+	if flags&0x01 != 0 && n < len(af) {
+		n += 1 + int(af[n]) // adaptation_field_extension
+	}
+	if n > len(af) {
+		n = len(af)
+	}
+	return append([]byte(nil), af[:n]...)
+}
+
+// psiSection 从带 pointer_field 的 payload 中取出完整的 PSI 节，不完整时返回 nil
+func psiSection(payload []byte) []byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	start := 1 + int(payload[0])
+	if start+3 > len(payload) {
+		return nil
+	}
+	section := payload[start:]
+	total := 3 + int(binary.BigEndian.Uint16(section[1:3])&0x0fff)
+	if total > len(section) || total < 12 {
+		return nil
+	}
+	return section[:total]
+}
+
+// findStartCodes 查找 Annex B 起始码，返回每个起始码的 [起始位置, NAL 起始位置]
+func findStartCodes(es []byte) [][2]int {
+	var starts [][2]int
+	for i := 0; i+3 <= len(es); i++ {
+		if es[i] == 0 && es[i+1] == 0 && es[i+2] == 1 {
+			begin := i
+			if begin > 0 && es[begin-1] == 0 {
+				begin-- // 4字节起始码 00 00 00 01
+			}
+			starts = append(starts, [2]int{begin, i + 3})
+			i += 2
+		}
+	}
+	return starts
+}
+
+// removeEmulationPrevention 去掉 00 00 03 中的防竞争字节 03
+func removeEmulationPrevention(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// addEmulationPrevention 在 00 00 后跟 00~03 的位置插入防竞争字节 03
+func addEmulationPrevention(raw []byte) []byte {
+	out := make([]byte, 0, len(raw)+len(raw)/64)
+	zeros := 0
+	for _, b := range raw {
+		if zeros >= 2 && b <= 0x03 {
+			out = append(out, 0x03)
+			zeros = 0
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	// NAL 以0结尾时也需要防竞争字节，否则会和下一个起始码混淆
+	if len(out) > 0 && out[len(out)-1] == 0 {
+		out = append(out, 0x03)
+	}
+	return out
+}
+
+// clonePacket 复制一个 TS 包，避免修改原始数据
+func clonePacket(pkt []byte) []byte {
+	return append([]byte(nil), pkt...)
+}
+
+// crc32MPEG 计算 MPEG-2 PSI 使用的 CRC32（多项式 0x04C11DB7，不反转）
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,632 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+const (
+	testPMTPID   = 0x100
+	testVideoPID = 0x101
+	testAACPID   = 0x102
+	testAC3PID   = 0x103
+	testPCRPID   = 0x1ff // 单独携带 PCR 的 PID，不在 PMT 中
+)
+
+var (
+	testKey = bytes.Repeat([]byte{0x2b}, 16)
+	testIV  = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+)
+
+func TestCRC32MPEG(t *testing.T) {
+	// CRC-32/MPEG-2 的标准校验值
+	if got := crc32MPEG([]byte("123456789")); got != 0x0376e6e7 {
+		t.Fatalf("crc32MPEG = %#08x, want 0x0376e6e7", got)
+	}
+}
+
+func TestDecryptSampleAESTS(t *testing.T) {
+	block, err := aes.NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 明文 ES：两个视频 PES（第一个带大量需要防竞争字节的数据，解密后包数会变化）、两个 ADTS PES、一个 AC-3 PES
+	clearVideo := [][]byte{
+		annexB(
+			[]byte{0x67, 0x42, 0x00, 0x1e, 0x00, 0x00, 0x01, 0x80}, // SPS，不加密
+			slice(0x65, 6000, 0), // IDR slice，几乎全是0
+			slice(0x41, 40, 1),   // 不超过48字节的 slice，不加密
+			slice(0x41, 700, 7),  // 普通 slice
+		),
+		annexB(slice(0x41, 1500, 3)),
+	}
+	clearAAC := [][]byte{
+		append(adtsFrame(100, 1), adtsFrame(250, 2)...),
+		adtsFrame(23, 3), // 16字节明文前导之后不足一个块，不加密
+	}
+	clearAC3 := [][]byte{append(ac3Frame(4), ac3Frame(5)...)}
+
+	var encVideo, encAAC, encAC3 [][]byte
+	for _, es := range clearVideo {
+		encVideo = append(encVideo, encryptH264(t, block, es))
+	}
+	for _, es := range clearAAC {
+		encAAC = append(encAAC, encryptFrames(block, es, 7, adtsLength))
+	}
+	for _, es := range clearAC3 {
+		encAC3 = append(encAC3, encryptFrames(block, es, 0, func([]byte) int { return 128 }))
+	}
+
+	m := &muxer{cc: make(map[uint16]byte)}
+	m.psi(0, patSection())
+	m.psi(testPMTPID, pmtSection())
+	m.pes(testVideoPID, pesPacket(0xe0, encVideo[0], false), true, 8)
+	m.pes(testAACPID, pesPacket(0xc0, encAAC[0], true), false, -1)
+	m.pes(testAC3PID, pesPacket(0xbd, encAC3[0], true), false, -1)
+	// PAT/PMT 周期性重复，改写后 PMT 包数变少，连续计数器仍要连续
+	m.psi(0, patSection())
+	m.psi(testPMTPID, pmtSection())
+	m.pes(testAACPID, pesPacket(0xc0, encAAC[1], true), false, -1)
+	m.pes(testVideoPID, pesPacket(0xe0, encVideo[1], false), true, 3)
+
+	out, err := decryptSampleAESTS(m.data, testKey, testIV)
+	if err != nil {
+		t.Fatalf("decryptSampleAESTS: %v", err)
+	}
+
+	d := demux(t, out)
+
+	if len(d.pmt) != 2 {
+		t.Fatalf("got %d PMT sections, want 2", len(d.pmt))
+	}
+	for _, section := range d.pmt {
+		checkPMT(t, section)
+	}
+
+	check := func(name string, pid uint16, want [][]byte) {
+		t.Helper()
+		got := d.pes[pid]
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d PES, want %d", name, len(got), len(want))
+		}
+		for i := range want {
+			es := pesPayload(t, got[i])
+			if !bytes.Equal(es, want[i]) {
+				t.Errorf("%s PES %d: elementary stream differs from clear input (len %d, want %d)", name, i, len(es), len(want[i]))
+			}
+		}
+	}
+	check("video", testVideoPID, clearVideo)
+	check("aac", testAACPID, clearAAC)
+	check("ac3", testAC3PID, clearAC3)
+
+	// PES 开头和中间只有调整字段的包里的 PCR 都要保留
+	if want := []uint64{8, 9, 3, 4}; !slices.Equal(d.pcrs, want) {
+		t.Errorf("PCR = %v, want %v", d.pcrs, want)
+	}
+}
+
+// 解密前后包数不同时，后面的包要接着改写后的连续计数器编号
+func TestDecryptSampleAESTSPacketCountChanges(t *testing.T) {
+	block, err := aes.NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clear := annexB(slice(0x65, 6000, 0))
+	enc := encryptH264(t, block, clear)
+	if len(enc) == len(clear) {
+		t.Fatal("fixture must change the PES size after decryption")
+	}
+
+	m := &muxer{cc: make(map[uint16]byte)}
+	m.psi(0, patSection())
+	m.psi(testPMTPID, pmtSection())
+	for i := 0; i < 3; i++ {
+		m.pes(testVideoPID, pesPacket(0xe0, enc, false), false, -1)
+	}
+	before := len(m.data) / tsPacketSize
+
+	out, err := decryptSampleAESTS(m.data, testKey, testIV)
+	if err != nil {
+		t.Fatalf("decryptSampleAESTS: %v", err)
+	}
+	if after := len(out) / tsPacketSize; after == before {
+		t.Fatalf("packet count did not change (%d)", after)
+	}
+	d := demux(t, out) // demux 检查连续计数器
+	if len(d.pes[testVideoPID]) != 3 {
+		t.Fatalf("got %d video PES, want 3", len(d.pes[testVideoPID]))
+	}
+}
+
+// 视频和音频交错封装时，解密后每个包仍在原来的位置，不会因为重新打包改变 PID 的交错顺序
+func TestDecryptSampleAESTSKeepsInterleaving(t *testing.T) {
+	block, err := aes.NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clearVideo := annexB(slice(0x65, 1500, 3))
+	clearAAC := [][]byte{adtsFrame(300, 1), adtsFrame(200, 2), adtsFrame(250, 3)}
+	encVideo := encryptH264(t, block, clearVideo)
+	if len(encVideo) != len(clearVideo) {
+		t.Fatal("fixture must keep the video PES size after decryption")
+	}
+
+	// 分别封装视频和音频，再和单独携带 PCR 的包交错：v a pcr v a pcr ...，音频用完后只剩视频和 PCR
+	video := &muxer{cc: make(map[uint16]byte)}
+	video.pes(testVideoPID, pesPacket(0xe0, encVideo, false), false, -1)
+	audio := &muxer{cc: make(map[uint16]byte)}
+	for _, es := range clearAAC {
+		audio.pes(testAACPID, pesPacket(0xc0, encryptFrames(block, es, 7, adtsLength), true), false, -1)
+	}
+	pcr := &muxer{cc: make(map[uint16]byte)}
+
+	m := &muxer{cc: make(map[uint16]byte)}
+	m.psi(0, patSection())
+	m.psi(testPMTPID, pmtSection())
+	psiPackets := len(m.data) / tsPacketSize
+	videoPackets, audioPackets := packets(video.data), packets(audio.data)
+	for i := 0; len(videoPackets) > 0 || len(audioPackets) > 0; i++ {
+		switch {
+		case i%3 == 2:
+			pcr.packet(testPCRPID, false, pcrField(uint64(i)), nil)
+			m.data = append(m.data, pcr.data[len(pcr.data)-tsPacketSize:]...)
+		case i%3 == 1 && len(audioPackets) > 0 || len(videoPackets) == 0:
+			m.data = append(m.data, audioPackets[0]...)
+			audioPackets = audioPackets[1:]
+		default:
+			m.data = append(m.data, videoPackets[0]...)
+			videoPackets = videoPackets[1:]
+		}
+	}
+
+	out, err := decryptSampleAESTS(m.data, testKey, testIV)
+	if err != nil {
+		t.Fatalf("decryptSampleAESTS: %v", err)
+	}
+
+	// 改写后的 PMT 只需要一个包，之后每个包的 PID 和输入一一对应
+	want := packetPIDs(m.data)
+	want = slices.Delete(want, psiPackets-1, psiPackets)
+	if got := packetPIDs(out); !slices.Equal(got, want) {
+		t.Errorf("packet PIDs\n got %#x\nwant %#x", got, want)
+	}
+
+	d := demux(t, out)
+	if es := d.pes[testVideoPID]; len(es) != 1 || !bytes.Equal(pesPayload(t, es[0]), clearVideo) {
+		t.Errorf("video PES differs from clear input")
+	}
+	if es := d.pes[testAACPID]; len(es) != len(clearAAC) {
+		t.Errorf("got %d AAC PES, want %d", len(es), len(clearAAC))
+	} else {
+		for i := range es {
+			if !bytes.Equal(pesPayload(t, es[i]), clearAAC[i]) {
+				t.Errorf("AAC PES %d differs from clear input", i)
+			}
+		}
+	}
+}
+
+// packets 把 TS 数据切分为包
+func packets(ts []byte) [][]byte {
+	var pkts [][]byte
+	for offset := 0; offset < len(ts); offset += tsPacketSize {
+		pkts = append(pkts, ts[offset:offset+tsPacketSize])
+	}
+	return pkts
+}
+
+// packetPIDs 按顺序列出每个包的 PID
+func packetPIDs(ts []byte) []uint16 {
+	var pids []uint16
+	for _, pkt := range packets(ts) {
+		pids = append(pids, binary.BigEndian.Uint16(pkt[1:3])&0x1fff)
+	}
+	return pids
+}
+
+// checkPMT 检查 PMT 的 CRC、加密流类型已改回普通类型、SAMPLE-AES 描述符已去掉而其他描述符保留
+func checkPMT(t *testing.T, section []byte) {
+	t.Helper()
+	if crc32MPEG(section) != 0 {
+		t.Errorf("PMT CRC32 mismatch")
+	}
+	programInfoLen := int(binary.BigEndian.Uint16(section[10:12]) & 0x0fff)
+	if programInfoLen != 120 {
+		t.Errorf("program_info_length = %d, want 120", programInfoLen)
+	}
+
+	types := make(map[uint16]byte)
+	var languages int
+	for i := 12 + programInfoLen; i < len(section)-4; {
+		pid := binary.BigEndian.Uint16(section[i+1:i+3]) & 0x1fff
+		types[pid] = section[i]
+		esInfoLen := int(binary.BigEndian.Uint16(section[i+3:i+5]) & 0x0fff)
+		descriptors := section[i+5 : i+5+esInfoLen]
+		for j := 0; j < len(descriptors); j += 2 + int(descriptors[j+1]) {
+			switch tag := descriptors[j]; {
+			case tag == 0x0f:
+				t.Errorf("PID %#x: private_data_indicator descriptor not removed", pid)
+			case tag == 0x05 && string(descriptors[j+2:j+6]) == "apad":
+				t.Errorf("PID %#x: apad registration descriptor not removed", pid)
+			case tag == 0x0a:
+				languages++
+			}
+		}
+		i += 5 + esInfoLen
+	}
+
+	want := map[uint16]byte{testVideoPID: 0x1b, testAACPID: 0x0f, testAC3PID: 0x81}
+	for pid, streamType := range want {
+		if types[pid] != streamType {
+			t.Errorf("PID %#x: stream_type %#x, want %#x", pid, types[pid], streamType)
+		}
+	}
+	if languages != 1 {
+		t.Errorf("got %d ISO 639 descriptors, want 1", languages)
+	}
+}
+
+// ---- 明文 ES ----
+
+// annexB 用起始码拼接 NAL
+func annexB(nals ...[]byte) []byte {
+	var es []byte
+	for _, nal := range nals {
+		es = append(es, 0, 0, 0, 1)
+		es = append(es, escape(nal)...)
+	}
+	return es
+}
+
+// slice 生成去掉防竞争字节之前的 slice NAL，seed 为0时内容全是0，最后一个字节是 rbsp 结束位
+func slice(header byte, size int, seed byte) []byte {
+	nal := make([]byte, size)
+	nal[0] = header
+	for i := 1; i < size; i++ {
+		if seed != 0 {
+			nal[i] = byte(i) * seed
+		}
+	}
+	nal[size-1] = 0x80
+	return nal
+}
+
+// adtsFrame 生成 protection_absent=1、帧体 bodyLen 字节的 ADTS 帧
+func adtsFrame(bodyLen int, seed byte) []byte {
+	frameLen := 7 + bodyLen
+	frame := make([]byte, frameLen)
+	frame[0], frame[1], frame[2] = 0xff, 0xf1, 0x50
+	frame[3] = 0x80 | byte(frameLen>>11)&0x03
+	frame[4] = byte(frameLen >> 3)
+	frame[5] = byte(frameLen<<5) | 0x1f
+	frame[6] = 0xfc
+	for i := 7; i < frameLen; i++ {
+		frame[i] = byte(i) ^ seed
+	}
+	return frame
+}
+
+// adtsLength 读取 ADTS 帧长度
+func adtsLength(frame []byte) int {
+	return int(frame[3]&0x03)<<11 | int(frame[4])<<3 | int(frame[5])>>5
+}
+
+// ac3Frame 生成 48kHz、frmsizecod=0（64个16位字）的 AC-3 帧
+func ac3Frame(seed byte) []byte {
+	frame := make([]byte, 128)
+	frame[0], frame[1] = 0x0b, 0x77
+	frame[4] = 0x00     // fscod=0, frmsizecod=0
+	frame[5] = 8<<3 | 0 // bsid=8
+	for i := 6; i < len(frame); i++ {
+		frame[i] = byte(i) * seed
+	}
+	return frame
+}
+
+// ---- 按 SAMPLE-AES 规则加密 ----
+
+// encryptH264 加密 slice NAL：去掉防竞争字节后前32字节明文，之后每160字节加密前16字节，
+// 每个 NAL 从 IV 开始一条 CBC 链，加密后重新插入防竞争字节
+func encryptH264(t *testing.T, block cipher.Block, es []byte) []byte {
+	t.Helper()
+	var out []byte
+	for _, nal := range splitAnnexB(es) {
+		raw := unescape(nal)
+		if len(raw) > 48 && (raw[0]&0x1f == 1 || raw[0]&0x1f == 5) {
+			mode := cipher.NewCBCEncrypter(block, testIV)
+			for pos := 32; len(raw)-pos > 16; pos += 160 {
+				mode.CryptBlocks(raw[pos:pos+16], raw[pos:pos+16])
+			}
+		}
+		out = append(out, 0, 0, 0, 1)
+		out = append(out, escape(raw)...)
+	}
+	return out
+}
+
+// encryptFrames 加密音频帧：帧头之后16字节明文，之后完整的16字节块用从 IV 开始的 CBC 链加密
+func encryptFrames(block cipher.Block, es []byte, headerLen int, frameLen func([]byte) int) []byte {
+	out := append([]byte(nil), es...)
+	for offset := 0; offset < len(out); {
+		n := frameLen(out[offset:])
+		body := out[offset+headerLen : offset+n]
+		if len(body) > 16 {
+			encrypted := body[16 : len(body)-len(body)%16]
+			cipher.NewCBCEncrypter(block, testIV).CryptBlocks(encrypted, encrypted)
+		}
+		offset += n
+	}
+	return out
+}
+
+// splitAnnexB 按4字节起始码拆出 NAL（测试数据只使用4字节起始码）
+func splitAnnexB(es []byte) [][]byte {
+	var nals [][]byte
+	for _, part := range bytes.Split(es, []byte{0, 0, 0, 1}) {
+		if len(part) > 0 {
+			nals = append(nals, part)
+		}
+	}
+	return nals
+}
+
+// escape 插入防竞争字节：00 00 后面是 00~03 时插入 03
+func escape(raw []byte) []byte {
+	var out []byte
+	zeros := 0
+	for _, b := range raw {
+		if zeros == 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+// unescape 去掉防竞争字节
+func unescape(nal []byte) []byte {
+	var out []byte
+	zeros := 0
+	for _, b := range nal {
+		if zeros == 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+// ---- TS 封装 ----
+
+// patSection 节目1的 PMT 在 testPMTPID
+func patSection() []byte {
+	body := []byte{0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xe0 | testPMTPID>>8, testPMTPID & 0xff}
+	return finishSection(0x00, body)
+}
+
+// pmtSection 三路加密流，program_info 较长，使 PMT 跨两个 TS 包；去掉 SAMPLE-AES 描述符后只需要一个包
+func pmtSection() []byte {
+	body := []byte{0x00, 0x01, 0xc1, 0x00, 0x00, 0xe0 | testVideoPID>>8, testVideoPID & 0xff}
+	programInfo := append([]byte{0xfe, 118}, bytes.Repeat([]byte{0xaa}, 118)...) // 用户私有描述符
+	body = binary.BigEndian.AppendUint16(body, 0xf000|uint16(len(programInfo)))
+	body = append(body, programInfo...)
+
+	apad := append([]byte{0x05, 44}, "apad"...)
+	apad = append(apad, bytes.Repeat([]byte{0x55}, 40)...)
+	body = appendStream(body, 0xdb, testVideoPID, append([]byte{0x0f, 4}, "zavc"...))
+	body = appendStream(body, 0xcf, testAACPID,
+		append(append(append([]byte{0x0f, 4}, "aacd"...), apad...), 0x0a, 4, 'e', 'n', 'g', 0))
+	body = appendStream(body, 0xc1, testAC3PID, append([]byte{0x0f, 4}, "ac3d"...))
+	return finishSection(0x02, body)
+}
+
+func appendStream(body []byte, streamType byte, pid uint16, descriptors []byte) []byte {
+	body = append(body, streamType, 0xe0|byte(pid>>8), byte(pid))
+	body = binary.BigEndian.AppendUint16(body, 0xf000|uint16(len(descriptors)))
+	return append(body, descriptors...)
+}
+
+// finishSection 加上 table_id、section_length 和 CRC
+func finishSection(tableID byte, body []byte) []byte {
+	sectionLen := len(body) + 4
+	section := []byte{tableID, 0xb0 | byte(sectionLen>>8), byte(sectionLen)}
+	section = append(section, body...)
+	return binary.BigEndian.AppendUint32(section, crc32MPEG(section))
+}
+
+// pesPacket 封装 PES，视频使用不限长度（PES_packet_length=0）
+func pesPacket(streamID byte, es []byte, withLength bool) []byte {
+	pes := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0x80, 5, 0x21, 0, 1, 0, 1}
+	if withLength {
+		binary.BigEndian.PutUint16(pes[4:6], uint16(len(pes)-6+len(es)))
+	}
+	return append(pes, es...)
+}
+
+// muxer 把节和 PES 切分为 TS 包
+type muxer struct {
+	data []byte
+	cc   map[uint16]byte
+}
+
+func (m *muxer) packet(pid uint16, pusi bool, af, payload []byte) int {
+	pkt := []byte{tsSyncByte, byte(pid>>8) & 0x1f, byte(pid), 0}
+	if pusi {
+		pkt[1] |= 0x40
+	}
+	control := byte(0x10)
+	if af != nil || len(payload) < tsPacketSize-4 {
+		// 调整字段填充到整个包
+		room := tsPacketSize - 4 - 1 - len(payload)
+		if room < len(af) {
+			room = len(af)
+		}
+		field := append([]byte(nil), af...)
+		if room > 0 && len(field) == 0 {
+			field = append(field, 0x00)
+		}
+		for len(field) < room {
+			field = append(field, 0xff)
+		}
+		control = 0x30
+		pkt = append(pkt, byte(len(field)))
+		pkt = append(pkt, field...)
+	}
+	n := min(len(payload), tsPacketSize-len(pkt))
+	if n == 0 && !pusi && af != nil {
+		control = 0x20 // 只有调整字段
+	}
+	pkt[3] = control | m.cc[pid]
+	if control&0x10 != 0 {
+		m.cc[pid] = (m.cc[pid] + 1) & 0x0f
+	}
+	pkt = append(pkt, payload[:n]...)
+	m.data = append(m.data, pkt...)
+	return n
+}
+
+// psi 输出一个节，pointer_field 为0，最后一个包用 0xFF 填充
+func (m *muxer) psi(pid uint16, section []byte) {
+	payload := append([]byte{0}, section...)
+	for first := true; len(payload) > 0; first = false {
+		chunk := make([]byte, tsPacketSize-4)
+		for i := range chunk {
+			chunk[i] = 0xff
+		}
+		n := copy(chunk, payload)
+		m.packet(pid, first, nil, chunk)
+		payload = payload[n:]
+	}
+}
+
+// pes 输出一个 PES；withPCR 时第一个包携带 PCR，第三个包之后插入一个只带 PCR 的包
+func (m *muxer) pes(pid uint16, pes []byte, withPCR bool, pcr int) {
+	for i := 0; len(pes) > 0; i++ {
+		var af []byte
+		if withPCR && i == 0 {
+			af = pcrField(uint64(pcr))
+		}
+		if withPCR && i == 3 {
+			m.packet(pid, false, pcrField(uint64(pcr+1)), nil)
+		}
+		n := m.packet(pid, i == 0, af, pes)
+		pes = pes[n:]
+	}
+}
+
+// pcrField 只带 PCR 的调整字段
+func pcrField(base uint64) []byte {
+	return []byte{0x10, byte(base >> 25), byte(base >> 17), byte(base >> 9), byte(base >> 1), byte(base<<7) | 0x7e, 0}
+}
+
+// ---- 解复用并检查输出 ----
+
+type demuxed struct {
+	pmt  [][]byte            // 完整的 PMT 节
+	pes  map[uint16][][]byte // 每个 PID 的完整 PES
+	pcrs []uint64            // 按出现顺序的 PCR base
+}
+
+// demux 检查同步字节和每个 PID 的连续计数器，并重组 PMT 和 PES
+func demux(t *testing.T, ts []byte) *demuxed {
+	t.Helper()
+	if len(ts)%tsPacketSize != 0 {
+		t.Fatalf("output length %d is not a multiple of %d", len(ts), tsPacketSize)
+	}
+
+	d := &demuxed{pes: make(map[uint16][][]byte)}
+	lastCC := make(map[uint16]byte)
+	current := make(map[uint16][]byte)
+	for offset := 0; offset < len(ts); offset += tsPacketSize {
+		pkt := ts[offset : offset+tsPacketSize]
+		if pkt[0] != tsSyncByte {
+			t.Fatalf("packet %d: missing sync byte", offset/tsPacketSize)
+		}
+		pid := binary.BigEndian.Uint16(pkt[1:3]) & 0x1fff
+		pusi := pkt[1]&0x40 != 0
+		control := pkt[3] >> 4 & 0x03
+		cc := pkt[3] & 0x0f
+
+		if last, ok := lastCC[pid]; ok {
+			want := last
+			if control&0x01 != 0 {
+				want = (last + 1) & 0x0f
+			}
+			if cc != want {
+				t.Fatalf("packet %d (PID %#x): continuity counter %d, want %d", offset/tsPacketSize, pid, cc, want)
+			}
+		}
+		lastCC[pid] = cc
+
+		pos := 4
+		if control&0x02 != 0 {
+			afLen := int(pkt[4])
+			if afLen > 0 && pkt[5]&0x10 != 0 {
+				p := pkt[6:12]
+				d.pcrs = append(d.pcrs, uint64(p[0])<<25|uint64(p[1])<<17|uint64(p[2])<<9|uint64(p[3])<<1|uint64(p[4])>>7)
+			}
+			pos = 5 + afLen
+		}
+		if control&0x01 == 0 {
+			continue
+		}
+		payload := pkt[pos:]
+
+		if pusi {
+			d.finish(pid, current[pid])
+			if pid == 0 || pid == testPMTPID {
+				payload = payload[1+int(payload[0]):]
+			}
+			current[pid] = append([]byte(nil), payload...)
+		} else {
+			current[pid] = append(current[pid], payload...)
+		}
+	}
+	for pid, data := range current {
+		d.finish(pid, data)
+	}
+	return d
+}
+
+func (d *demuxed) finish(pid uint16, data []byte) {
+	switch {
+	case data == nil || pid == 0:
+	case pid == testPMTPID:
+		total := 3 + int(binary.BigEndian.Uint16(data[1:3])&0x0fff)
+		d.pmt = append(d.pmt, data[:total])
+	default:
+		d.pes[pid] = append(d.pes[pid], data)
+	}
+}
+
+// pesPayload 检查 PES_packet_length 并取出 ES
+func pesPayload(t *testing.T, pes []byte) []byte {
+	t.Helper()
+	if !bytes.HasPrefix(pes, []byte{0, 0, 1}) {
+		t.Fatalf("missing PES start code")
+	}
+	if length := int(binary.BigEndian.Uint16(pes[4:6])); length != 0 && length != len(pes)-6 {
+		t.Errorf("PES_packet_length %d, want %d", length, len(pes)-6)
+	}
+	return pes[9+int(pes[8]):]
+}
@@ -0,0 +1,61 @@
+package storage  // 存储包，负责文件的下载和存储管理
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// StateFileName 保存目录中记录已下载片段的状态文件名，重启后据此续录
+const StateFileName = ".hls_state.json"
+
+// SegmentRecord 一个已下载片段的记录
+type SegmentRecord struct {
+	ID            string    `json:"id"`             // 片段ID，用于去重
+	MediaSequence int       `json:"media_sequence"` // 媒体序列号
+	URI           string    `json:"uri"`            // 片段地址
+	Size          int64     `json:"size"`           // 保存文件的字节数
+	Checksum      string    `json:"sha256"`         // 保存文件的 SHA-256
+	File          string    `json:"file"`           // 保存的文件名（相对保存目录）
+	DownloadedAt  time.Time `json:"downloaded_at"`  // 下载完成时间
+}
+
+// ResumeState 一路媒体列表的续录状态
+type ResumeState struct {
+	PlaylistURL string          `json:"playlist_url"` // 媒体播放列表地址
+	UpdatedAt   time.Time       `json:"updated_at"`   // 最近一次保存的时间
+	Segments    []SegmentRecord `json:"segments"`     // 已下载的片段
+}
+
+// LoadState 读取保存目录中的状态文件，文件不存在时返回空状态
+func LoadState(dir string) (*ResumeState, error) {
+	data, err := os.ReadFile(path.Join(dir, StateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return &ResumeState{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取状态文件失败: %w", err)
+	}
+
+	state := &ResumeState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("解析状态文件失败: %w", err)
+	}
+	return state, nil
+}
+
+// SaveState 原子地写入状态文件，程序中途崩溃也不会留下损坏的状态
+func SaveState(dir string, state *ResumeState) error {
+	state.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化状态失败: %w", err)
+	}
+	if err := writeFileAtomic(path.Join(dir, StateFileName), data, 0644); err != nil {
+		return fmt.Errorf("写入状态文件失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package hls
+
+import (
+	"time"
+
+	"github.com/MGter/hls_downloader/internal/downloader"
+)
+
+// EventType 录制事件的类型
+type EventType string
+
+const (
+	EventRecordingStarted EventType = downloader.EventRecordingStarted // 解析出要录制的媒体列表，开始录制
+	EventPlaylistReloaded EventType = downloader.EventPlaylistReloaded // 成功获取了一次媒体播放列表
+	EventVariantSwitched  EventType = downloader.EventVariantSwitched  // 从主播放列表切换到选中的码率版本
+	EventSegmentStarted   EventType = downloader.EventSegmentStarted   // 片段开始下载，File 为将要保存的路径
+	EventSegmentCompleted EventType = downloader.EventSegmentCompleted // 片段已保存到磁盘
+	EventSegmentFailed    EventType = downloader.EventSegmentFailed    // 片段重试用尽仍下载失败，下次刷新列表时还会再试
+	EventDiscontinuity    EventType = downloader.EventDiscontinuity    // 遇到 EXT-X-DISCONTINUITY，Segment 是不连续点之后第一个保存的片段
+	EventStreamEnded      EventType = downloader.EventStreamEnded      // 播放列表已结束（ENDLIST / VOD），这一路不会再有新片段
+	EventStalled          EventType = downloader.EventStalled          // 播放列表超过 1.5 倍目标时长没有更新
+	EventRepeatedFailure  EventType = downloader.EventRepeatedFailure  // 连续多轮刷新或下载失败，达到 WithFailureThreshold 的阈值
+	EventDiskQuota        EventType = downloader.EventDiskQuota        // 保存目录超过 WithDiskQuota 的配额，录制随后停止
+	EventError            EventType = downloader.EventError            // 片段之外的错误（例如播放列表获取失败），录制会在等待后继续
+)
+
+// Event 录制过程中发生的事件
+type Event struct {
+	Type     EventType     // 事件类型
+	Time     time.Time     // 事件发生的时间
+	Track    string        // 媒体列表名称，例如 "main"、"audio_aac_English"
+	Playlist *PlaylistInfo // 播放列表信息，只有 EventPlaylistReloaded 有
+	Variant  *VariantInfo  // 选中的码率版本，只有 EventVariantSwitched 有
+	Segment  *SegmentInfo  // 片段信息，片段事件和 EventDiscontinuity 有
+	Stream   *StreamInfo   // 结束时的统计，只有 EventStreamEnded 有
+	Info     *Info         // 其他事件的详情：EventRecordingStarted、EventStalled、EventRepeatedFailure、EventDiskQuota
+	Err      error         // 失败原因，EventSegmentFailed、EventError、EventRepeatedFailure 和部分片段失败的 EventStreamEnded 有
+}
+
+// Info 录制开始、停滞、连续失败和超过配额事件的详情，只填写与事件有关的字段
+type Info struct {
+	URL       string        // 入口或媒体播放列表地址
+	OutputDir string        // 保存目录（EventRecordingStarted、EventDiskQuota）
+	Tracks    []string      // 要录制的媒体列表名称（EventRecordingStarted）
+	Stalled   time.Duration // 播放列表已经多久没有更新（EventStalled）
+	Failures  int           // 连续失败的轮数（EventRepeatedFailure）
+	Quota     int64         // 磁盘配额（字节）（EventDiskQuota）
+	Used      int64         // 保存目录当前的占用（字节）（EventDiskQuota）
+}
+
+// PlaylistInfo 一次刷新得到的媒体播放列表信息
+type PlaylistInfo struct {
+	URL            string        // 媒体播放列表地址
+	MediaSequence  int           // 列表第一个片段的媒体序列号
+	Segments       int           // 列表中的片段数
+	TargetDuration time.Duration // EXT-X-TARGETDURATION
+	Changed        bool          // 与上一次刷新相比列表是否有变化
+	Ended          bool          // 列表已经结束（ENDLIST / VOD）
+}
+
+// VariantInfo 选中的码率版本
+type VariantInfo struct {
+	MasterURL string        // 主播放列表地址
+	URI       string        // 选中的媒体播放列表地址
+	Policy    VariantPolicy // 使用的选择策略
+	Variants  int           // 主播放列表中的码率版本数
+	Bandwidth int           // BANDWIDTH（bit/s）
+	Width     int           // 分辨率宽度，0 表示未提供
+	Height    int           // 分辨率高度，0 表示未提供
+	Codecs    []string      // CODECS
+}
+
+// SegmentInfo 片段的元数据和下载结果
+type SegmentInfo struct {
+	URI                   string        // 片段地址
+	Sequence              int           // 媒体序列号
+	DiscontinuitySequence int           // 不连续序列号
+	Discontinuity         bool          // 片段前是否有 EXT-X-DISCONTINUITY
+	Duration              time.Duration // EXTINF 时长
+	ProgramDateTime       time.Time     // 片段第一帧的绝对时间，零值表示未知
+	File                  string        // 保存路径，开始下载时为将要保存的路径，失败时为空
+	Size                  int64         // 写入文件的字节数
+	Checksum              string        // 文件内容的 SHA-256（十六进制）
+	Attempts              int           // 实际发出的请求次数
+}
+
+// StreamInfo 一路媒体列表结束时的统计
+type StreamInfo struct {
+	URL    string // 媒体播放列表地址
+	Queued int    // 提交下载的片段数
+	Bytes  int64  // 已保存的字节数
+	Lost   int    // 永久丢失和最终没有下载成功的片段数
+}
+
+// Handler 接收录制事件。HandleEvent 在录制协程和片段下载协程中同步调用，多路媒体列表和同一批的多个片段会并发调用；
+// 耗时的处理（上传、通知等）应交给其他协程，否则会拖慢录制
+type Handler interface {
+	HandleEvent(Event)
+}
+
+// HandlerFunc 让普通函数可以作为 Handler 使用
+type HandlerFunc func(Event)
+
+// HandleEvent 调用 f(e)
+func (f HandlerFunc) HandleEvent(e Event) { f(e) }
+
+// observer 把内部下载器的回调转换为 Event 交给 Handler
+type observer struct {
+	handler Handler
+}
+
+func (o observer) RecordingStarted(e downloader.RecordingEvent) {
+	o.handler.HandleEvent(Event{
+		Type: EventRecordingStarted,
+		Time: time.Now(),
+		Info: &Info{URL: e.URL, OutputDir: e.OutputDir, Tracks: e.Tracks},
+	})
+}
+
+func (o observer) PlaylistReloaded(e downloader.PlaylistEvent) {
+	o.handler.HandleEvent(Event{
+		Type:  EventPlaylistReloaded,
+		Time:  time.Now(),
+		Track: e.Track,
+		Playlist: &PlaylistInfo{
+			URL:            e.URL,
+			MediaSequence:  e.MediaSequence,
+			Segments:       e.Segments,
+			TargetDuration: e.TargetDuration,
+			Changed:        e.Changed,
+			Ended:          e.Ended,
+		},
+	})
+}
+
+func (o observer) VariantSwitched(e downloader.VariantEvent) {
+	o.handler.HandleEvent(Event{
+		Type:  EventVariantSwitched,
+		Time:  time.Now(),
+		Track: "main",
+		Variant: &VariantInfo{
+			MasterURL: e.MasterURL,
+			URI:       e.URI,
+			Policy:    VariantPolicy(e.Policy),
+			Variants:  e.Variants,
+			Bandwidth: e.Bandwidth,
+			Width:     e.Width,
+			Height:    e.Height,
+			Codecs:    e.Codecs,
+		},
+	})
+}
+
+func (o observer) SegmentStarted(e downloader.SegmentEvent) {
+	o.handler.HandleEvent(segmentEvent(EventSegmentStarted, e))
+}
+
+func (o observer) SegmentCompleted(e downloader.SegmentEvent) {
+	o.handler.HandleEvent(segmentEvent(EventSegmentCompleted, e))
+}
+
+func (o observer) SegmentFailed(e downloader.SegmentEvent) {
+	o.handler.HandleEvent(segmentEvent(EventSegmentFailed, e))
+}
+
+func (o observer) Discontinuity(e downloader.SegmentEvent) {
+	o.handler.HandleEvent(segmentEvent(EventDiscontinuity, e))
+}
+
+func (o observer) StreamEnded(e downloader.StreamEvent) {
+	o.handler.HandleEvent(Event{
+		Type:   EventStreamEnded,
+		Time:   time.Now(),
+		Track:  e.Track,
+		Stream: &StreamInfo{URL: e.URL, Queued: e.Queued, Bytes: e.Bytes, Lost: e.Lost},
+		Err:    e.Err,
+	})
+}
+
+func (o observer) Stalled(e downloader.StallEvent) {
+	o.handler.HandleEvent(Event{
+		Type:  EventStalled,
+		Time:  time.Now(),
+		Track: e.Track,
+		Info:  &Info{URL: e.URL, Stalled: e.Since},
+	})
+}
+
+func (o observer) RepeatedFailure(e downloader.FailureEvent) {
+	o.handler.HandleEvent(Event{
+		Type:  EventRepeatedFailure,
+		Time:  time.Now(),
+		Track: e.Track,
+		Info:  &Info{URL: e.URL, Failures: e.Count},
+		Err:   e.Err,
+	})
+}
+
+func (o observer) DiskQuotaExceeded(e downloader.QuotaEvent) {
+	o.handler.HandleEvent(Event{
+		Type: EventDiskQuota,
+		Time: time.Now(),
+		Info: &Info{OutputDir: e.OutputDir, Quota: e.Limit, Used: e.Used},
+	})
+}
+
+func (o observer) Error(e downloader.ErrorEvent) {
+	o.handler.HandleEvent(Event{Type: EventError, Time: time.Now(), Track: e.Track, Err: e.Err})
+}
+
+// segmentEvent 把内部的片段事件转换为公开的 Event
+func segmentEvent(t EventType, e downloader.SegmentEvent) Event {
+	return Event{
+		Type:  t,
+		Time:  time.Now(),
+		Track: e.Track,
+		Segment: &SegmentInfo{
+			URI:                   e.URI,
+			Sequence:              e.Sequence,
+			DiscontinuitySequence: e.DiscontinuitySequence,
+			Discontinuity:         e.Discontinuity,
+			Duration:              e.Duration,
+			ProgramDateTime:       e.ProgramDateTime,
+			File:                  e.File,
+			Size:                  e.Size,
+			Checksum:              e.Checksum,
+			Attempts:              e.Attempts,
+		},
+		Err: e.Err,
+	}
+}
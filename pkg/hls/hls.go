@@ -0,0 +1,145 @@
+// Package hls 提供可以嵌入其他程序的 HLS 录制接口。
+//
+// 用法：
+//
+//	d, err := hls.New("https://example.com/live/index.m3u8",
+//		hls.WithOutputDir("records/live"),
+//		hls.WithHandler(hls.HandlerFunc(func(e hls.Event) { ... })),
+//	)
+//	if err != nil { ... }
+//	result, err := d.Start(ctx)
+//
+// 直播流会一直录制到 ctx 被取消，VOD 或已结束的列表下载完成后 Start 返回。
+// 进度日志写入标准库 log 的默认 Logger，可以用 log.SetOutput 重定向；
+// 警告和错误（片段丢失、列表停滞、超出配额等）写入 pkg/logger 的 Warn 和 Error。
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/MGter/hls_downloader/internal/downloader"
+	"github.com/MGter/hls_downloader/pkg/utils"
+)
+
+// Downloader 一个 HLS 流的录制器，由 New 创建
+type Downloader struct {
+	url      string                      // 入口播放列表地址（主播放列表或媒体播放列表）
+	config   downloader.Config           // 内部下载器配置
+	handler  Handler                     // 录制事件的接收者，可以为 nil
+	webhooks []downloader.WebhookOptions // 事件回调地址，每次 Start 时创建
+
+	// 以下设置在所有选项之后叠加到 config.HTTP 上，与 WithHTTPOptions 的先后顺序无关
+	headers http.Header         // WithHeader 添加的请求头
+	jar     http.CookieJar      // WithCookieJar 指定的 Cookie 容器
+	proxy   *utils.ProxyOptions // WithProxy 指定的代理
+}
+
+// New 创建录制 url 的下载器，未指定的设置使用默认值；选项或配置无效时返回错误
+func New(url string, opts ...Option) (*Downloader, error) {
+	if url == "" {
+		return nil, errors.New("播放列表地址不能为空")
+	}
+
+	d := &Downloader{url: url, config: downloader.DefaultConfig()}
+	var errs []error
+	for _, opt := range opts {
+		errs = append(errs, opt(d))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	d.applyHTTPOverrides()
+	if err := d.config.Validate(); err != nil {
+		return nil, fmt.Errorf("配置无效: %w", err)
+	}
+	return d, nil
+}
+
+// Start 开始录制并阻塞到录制结束。ctx 取消后进行中的片段最多再下载停止宽限时间，然后返回 nil 错误。
+// 只要开始了录制，即使返回错误也会同时返回录制结果。同一个 Downloader 可以再次 Start，
+// 已经保存的片段会按保存目录中的续录状态跳过
+func (d *Downloader) Start(ctx context.Context) (*Result, error) {
+	config := d.config
+	var observers []downloader.Observer
+	if d.handler != nil {
+		observers = append(observers, observer{handler: d.handler})
+	}
+	for _, options := range d.webhooks {
+		webhook, err := downloader.NewWebhookObserver(options)
+		if err != nil {
+			return nil, err
+		}
+		defer webhook.Close() // 返回前把队列中的事件发送完
+		observers = append(observers, webhook)
+	}
+	config.Observer = downloader.MultiObserver(observers...)
+
+	result, err := downloader.NewWithConfig(config).Start(ctx, d.url)
+	return newResult(result), err
+}
+
+// Result 一次录制的结果
+type Result struct {
+	OutputDir string        // 保存目录
+	Ended     bool          // 所有媒体列表都已结束（ENDLIST / VOD）；false 表示录制被停止
+	Tracks    []TrackResult // 每路媒体列表（主码流、音频、字幕）的统计，还没开始录制就停止时为空
+}
+
+// TrackResult 一路媒体列表的录制统计
+type TrackResult struct {
+	Name            string         // 媒体列表名称，例如 "main"、"audio_aac_English"
+	PlaylistURL     string         // 媒体播放列表地址
+	OutputDir       string         // 片段保存目录
+	Ended           bool           // 列表是否已经结束
+	Queued          int            // 提交下载的片段数，重试的片段会重复计数
+	Bytes           int64          // 已保存的字节数
+	FailedBatches   int            // 有片段下载失败的批次数
+	Failures        int            // 片段重试用尽后失败的次数
+	FailuresByClass map[string]int // 按失败分类（http_4xx、timeout 等）统计的失败次数
+	LostCount       int            // 永久丢失的片段数
+	Lost            []LostSegment  // 丢失的片段，最多列出 1000 个
+}
+
+// LostSegment 没有下载成功的片段
+type LostSegment struct {
+	Sequence int    // 媒体序列号
+	URI      string // 片段地址
+	Attempts int    // 下载过的轮数
+	Err      error  // 最后一次失败的原因
+}
+
+// newResult 把内部的录制结果转换为公开的类型
+func newResult(r *downloader.Result) *Result {
+	if r == nil {
+		return nil
+	}
+
+	result := &Result{OutputDir: r.OutputDir, Ended: r.Ended}
+	for _, t := range r.Tracks {
+		track := TrackResult{
+			Name:          t.Name,
+			PlaylistURL:   t.PlaylistURL,
+			OutputDir:     t.OutputDir,
+			Ended:         t.Ended,
+			Queued:        t.Queued,
+			Bytes:         t.Bytes,
+			FailedBatches: t.FailedBatches,
+			Failures:      t.Failures,
+			LostCount:     t.LostCount,
+		}
+		if len(t.FailuresByClass) > 0 {
+			track.FailuresByClass = make(map[string]int, len(t.FailuresByClass))
+			for class, n := range t.FailuresByClass {
+				track.FailuresByClass[string(class)] = n
+			}
+		}
+		for _, l := range t.Lost {
+			track.Lost = append(track.Lost, LostSegment{Sequence: l.Sequence, URI: l.URI, Attempts: l.Attempts, Err: l.Err})
+		}
+		result.Tracks = append(result.Tracks, track)
+	}
+	return result
+}
@@ -0,0 +1,210 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MGter/hls_downloader/internal/downloader"
+	"github.com/MGter/hls_downloader/internal/storage"
+)
+
+// newVODServer 提供一个主播放列表（两个码率）和一个已结束的媒体列表，片段内容是片段名
+func newVODServer(t *testing.T, segments int) *httptest.Server {
+	master := `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360
+low.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2400000,RESOLUTION=1280x720
+high.m3u8
+`
+	var media strings.Builder
+	media.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:10\n")
+	for i := 0; i < segments; i++ {
+		fmt.Fprintf(&media, "#EXTINF:4.0,\nseg%d.ts\n", i)
+	}
+	media.WriteString("#EXT-X-ENDLIST\n")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/master.m3u8":
+			fmt.Fprint(w, master)
+		case r.URL.Path == "/high.m3u8":
+			fmt.Fprint(w, media.String())
+		case strings.HasSuffix(r.URL.Path, ".ts"):
+			fmt.Fprint(w, strings.TrimPrefix(r.URL.Path, "/"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// eventRecorder 收集 Handler 收到的事件，片段事件会被并发调用
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) HandleEvent(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) ofType(typ EventType) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []Event
+	for _, e := range r.events {
+		if e.Type == typ {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Start 把录制过程中的事件交给 Handler，并返回转换后的录制结果
+func TestStartDeliversEvents(t *testing.T) {
+	const segments = 3
+	server := newVODServer(t, segments)
+	outputDir := t.TempDir()
+	recorder := &eventRecorder{}
+
+	d, err := New(server.URL+"/master.m3u8",
+		WithOutputDir(outputDir),
+		WithHandler(recorder),
+		WithRetry(1, time.Millisecond, time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := d.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	started := recorder.ofType(EventRecordingStarted)
+	if len(started) != 1 || started[0].Info == nil || started[0].Info.OutputDir != outputDir || !slices.Equal(started[0].Info.Tracks, []string{"main"}) {
+		t.Errorf("recording started events %+v", started)
+	}
+	switched := recorder.ofType(EventVariantSwitched)
+	if len(switched) != 1 || switched[0].Variant == nil || switched[0].Variant.Bandwidth != 2400000 ||
+		switched[0].Variant.Policy != VariantHighest || switched[0].Variant.Variants != 2 {
+		t.Errorf("variant switched events %+v", switched)
+	}
+	reloaded := recorder.ofType(EventPlaylistReloaded)
+	if len(reloaded) == 0 || reloaded[0].Playlist == nil || reloaded[0].Playlist.MediaSequence != 10 || !reloaded[0].Playlist.Ended {
+		t.Errorf("playlist reloaded events %+v", reloaded)
+	}
+
+	completed := recorder.ofType(EventSegmentCompleted)
+	if len(completed) != segments {
+		t.Fatalf("got %d segment completed events, want %d", len(completed), segments)
+	}
+	var sequences []int
+	for _, e := range completed {
+		s := e.Segment
+		if s == nil || e.Track != "main" {
+			t.Fatalf("segment completed event %+v", e)
+		}
+		sequences = append(sequences, s.Sequence)
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			t.Errorf("segment %d: %v", s.Sequence, err)
+			continue
+		}
+		if want := fmt.Sprintf("seg%d.ts", s.Sequence-10); string(data) != want || s.Size != int64(len(want)) {
+			t.Errorf("segment %d: file %q (size %d), want %q", s.Sequence, data, s.Size, want)
+		}
+		if s.Duration != 4*time.Second || s.Checksum == "" || s.Attempts != 1 {
+			t.Errorf("segment %d info %+v", s.Sequence, s)
+		}
+	}
+	slices.Sort(sequences)
+	if !slices.Equal(sequences, []int{10, 11, 12}) {
+		t.Errorf("completed sequences %v", sequences)
+	}
+	if n := len(recorder.ofType(EventSegmentStarted)); n != segments {
+		t.Errorf("got %d segment started events, want %d", n, segments)
+	}
+	if failed := recorder.ofType(EventSegmentFailed); len(failed) != 0 {
+		t.Errorf("unexpected segment failures %+v", failed)
+	}
+
+	ended := recorder.ofType(EventStreamEnded)
+	if len(ended) != 1 || ended[0].Stream == nil || ended[0].Stream.Queued != segments || ended[0].Stream.Lost != 0 || ended[0].Err != nil {
+		t.Errorf("stream ended events %+v", ended)
+	}
+
+	if result == nil || !result.Ended || result.OutputDir != outputDir || len(result.Tracks) != 1 {
+		t.Fatalf("result %+v", result)
+	}
+	track := result.Tracks[0]
+	if track.Name != "main" || track.PlaylistURL != server.URL+"/high.m3u8" || !track.Ended || track.Queued != segments || track.Bytes != 3*int64(len("seg0.ts")) {
+		t.Errorf("track result %+v", track)
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	if newResult(nil) != nil {
+		t.Error("newResult(nil) != nil")
+	}
+
+	lostErr := errors.New("404 Not Found")
+	r := newResult(&downloader.Result{
+		OutputDir: "records/live",
+		Ended:     true,
+		Tracks: []downloader.TrackResult{
+			{
+				Name:            "main",
+				PlaylistURL:     "https://example.com/high.m3u8",
+				OutputDir:       "records/live",
+				Ended:           true,
+				Queued:          12,
+				Bytes:           4096,
+				FailedBatches:   2,
+				Failures:        3,
+				FailuresByClass: map[storage.ErrorClass]int{storage.ClassHTTPClient: 2, storage.ClassTimeout: 1},
+				LostCount:       1,
+				Lost:            []downloader.LostSegment{{Sequence: 7, URI: "https://example.com/seg7.ts", Attempts: 3, Err: lostErr}},
+			},
+			{Name: "audio_aac_English", PlaylistURL: "https://example.com/en.m3u8", OutputDir: "records/live/audio_aac_English"},
+		},
+	})
+
+	if r.OutputDir != "records/live" || !r.Ended || len(r.Tracks) != 2 {
+		t.Fatalf("result %+v", r)
+	}
+	main := r.Tracks[0]
+	if main.Name != "main" || main.PlaylistURL != "https://example.com/high.m3u8" || main.OutputDir != "records/live" || !main.Ended ||
+		main.Queued != 12 || main.Bytes != 4096 || main.FailedBatches != 2 || main.Failures != 3 || main.LostCount != 1 {
+		t.Errorf("main track %+v", main)
+	}
+	if want := map[string]int{"http_4xx": 2, "timeout": 1}; !maps.Equal(main.FailuresByClass, want) {
+		t.Errorf("FailuresByClass %v, want %v", main.FailuresByClass, want)
+	}
+	if len(main.Lost) != 1 {
+		t.Fatalf("lost segments %+v", main.Lost)
+	}
+	if l := main.Lost[0]; l.Sequence != 7 || l.URI != "https://example.com/seg7.ts" || l.Attempts != 3 || !errors.Is(l.Err, lostErr) {
+		t.Errorf("lost segment %+v", l)
+	}
+
+	// 没有失败的媒体列表不分配空的统计
+	audio := r.Tracks[1]
+	if audio.Name != "audio_aac_English" || audio.OutputDir != "records/live/audio_aac_English" || audio.FailuresByClass != nil || audio.Lost != nil {
+		t.Errorf("audio track %+v", audio)
+	}
+}
@@ -0,0 +1,299 @@
+package hls
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MGter/hls_downloader/internal/downloader"
+	"github.com/MGter/hls_downloader/pkg/utils"
+)
+
+// Option 创建 Downloader 时的设置项
+type Option func(*Downloader) error
+
+// VariantPolicy 主播放列表的码率版本选择策略
+type VariantPolicy string
+
+const (
+	VariantHighest    VariantPolicy = "highest"     // 码率最高的版本（默认）
+	VariantLowest     VariantPolicy = "lowest"      // 码率最低的版本
+	VariantResolution VariantPolicy = "resolution"  // 分辨率最接近目标的版本
+	VariantMaxBitrate VariantPolicy = "max-bitrate" // 不超过码率上限的最高码率版本
+	VariantIndex      VariantPolicy = "index"       // 按主播放列表中的顺序指定
+	VariantFirst      VariantPolicy = "first"       // 列表中的第一个版本
+)
+
+// Variant 码率版本选择条件
+type Variant struct {
+	Policy       VariantPolicy // 选择策略
+	Width        int           // resolution 策略的目标宽度
+	Height       int           // resolution 策略的目标高度
+	MaxBandwidth int           // 码率上限（bit/s），0 表示不限制；对所有策略生效
+	Codecs       []string      // 编码偏好，按优先级排列，例如 ["avc1", "hevc"]
+	Index        int           // index 策略使用的版本序号（从0开始）
+}
+
+// Renditions 需要同时录制的备用音频/字幕渲染
+type Renditions struct {
+	Audio       bool     // 录制所选版本引用的 AUDIO 组
+	Subtitles   bool     // 录制所选版本引用的 SUBTITLES 组
+	Languages   []string // 只录制这些语言，前缀匹配（"en" 匹配 "en-US"），空表示不限制
+	Names       []string // 只录制这些 NAME，空表示不限制
+	DefaultOnly bool     // 只录制 DEFAULT=YES 的渲染
+}
+
+// SegmentIdentity 片段去重使用的标识策略
+type SegmentIdentity string
+
+const (
+	IdentitySequence SegmentIdentity = "sequence" // 不连续序列号 + 媒体序列号（默认）
+	IdentityFilename SegmentIdentity = "filename" // 文件名末尾的数字，用于媒体序列号不可靠的源
+)
+
+// WithOutputDir 设置保存目录，默认根据地址生成
+func WithOutputDir(dir string) Option {
+	return func(d *Downloader) error {
+		d.config.OutputDir = dir
+		return nil
+	}
+}
+
+// WithMaxDuration 设置录制时长上限，到达后像 ctx 被取消一样结束，0 表示不限制
+func WithMaxDuration(duration time.Duration) Option {
+	return func(d *Downloader) error {
+		d.config.MaxDuration = duration
+		return nil
+	}
+}
+
+// WithConcurrency 设置同时下载的片段数，默认 8
+func WithConcurrency(n int) Option {
+	return func(d *Downloader) error {
+		d.config.MaxConcurrentDownloads = n
+		return nil
+	}
+}
+
+// WithRetry 设置每个请求的最大尝试次数、第一次重试前的退避上限和两次重试之间的最长等待时间
+func WithRetry(attempts int, baseDelay, maxDelay time.Duration) Option {
+	return func(d *Downloader) error {
+		d.config.MaxRetryAttempts = attempts
+		d.config.RetryDelayBase = baseDelay
+		d.config.RetryMaxDelay = maxDelay
+		return nil
+	}
+}
+
+// WithPollInterval 设置没有目标时长信息或出错时检查新片段的间隔，默认 5 秒
+func WithPollInterval(interval time.Duration) Option {
+	return func(d *Downloader) error {
+		d.config.DownloadInterval = interval
+		return nil
+	}
+}
+
+// WithReloadInterval 设置按目标时长计算的刷新间隔的上下限，0 表示不限制
+func WithReloadInterval(min, max time.Duration) Option {
+	return func(d *Downloader) error {
+		d.config.MinReloadInterval = min
+		d.config.MaxReloadInterval = max
+		return nil
+	}
+}
+
+// WithVariant 设置主播放列表的码率版本选择条件
+func WithVariant(v Variant) Option {
+	return func(d *Downloader) error {
+		d.config.VariantSelection = downloader.VariantSelection{
+			Policy:          downloader.VariantPolicy(v.Policy),
+			TargetWidth:     v.Width,
+			TargetHeight:    v.Height,
+			MaxBandwidth:    v.MaxBandwidth,
+			CodecPreference: v.Codecs,
+			Index:           v.Index,
+		}
+		return nil
+	}
+}
+
+// WithRenditions 设置需要同时录制的备用音频/字幕渲染，默认录制所有音频和字幕
+func WithRenditions(r Renditions) Option {
+	return func(d *Downloader) error {
+		d.config.Renditions = downloader.RenditionFilter{
+			Audio:       r.Audio,
+			Subtitles:   r.Subtitles,
+			Languages:   r.Languages,
+			Names:       r.Names,
+			DefaultOnly: r.DefaultOnly,
+		}
+		return nil
+	}
+}
+
+// WithSegmentIdentity 设置片段去重使用的标识策略
+func WithSegmentIdentity(identity SegmentIdentity) Option {
+	return func(d *Downloader) error {
+		d.config.SegmentIdentity = downloader.SegmentIdentity(identity)
+		return nil
+	}
+}
+
+// WithKeepEncrypted 解密的同时保留加密原文件和密钥，用于归档
+func WithKeepEncrypted(keep bool) Option {
+	return func(d *Downloader) error {
+		d.config.KeepEncrypted = keep
+		return nil
+	}
+}
+
+// WithPrefixInitSegment 在每个 fMP4 分片前拼接初始化片段，生成可独立播放的文件
+func WithPrefixInitSegment(prefix bool) Option {
+	return func(d *Downloader) error {
+		d.config.PrefixInitSegment = prefix
+		return nil
+	}
+}
+
+// WithShutdownGrace 设置 ctx 取消后等待进行中的片段下载完成的最长时间，0 表示立即中断
+func WithShutdownGrace(grace time.Duration) Option {
+	return func(d *Downloader) error {
+		d.config.ShutdownGracePeriod = grace
+		return nil
+	}
+}
+
+// WithHTTPOptions 替换全部 HTTP 设置（超时、慢速检测、请求头、Cookie、代理），
+// 一般以 utils.DefaultClientOptions() 为基础修改。WithHeader、WithCookieJar 和 WithProxy
+// 与顺序无关，总是叠加在这里的设置之上：请求头追加，Cookie 容器和代理替换
+func WithHTTPOptions(options utils.ClientOptions) Option {
+	return func(d *Downloader) error {
+		d.config.HTTP = options
+		return nil
+	}
+}
+
+// WithHeader 给播放列表、密钥和片段请求都加上一个请求头，可以多次使用
+func WithHeader(name, value string) Option {
+	return func(d *Downloader) error {
+		if d.headers == nil {
+			d.headers = make(http.Header)
+		}
+		d.headers.Add(name, value)
+		return nil
+	}
+}
+
+// WithCookieJar 使用指定的 Cookie 容器，例如 utils.LoadCookieFile 读取的浏览器 Cookie
+func WithCookieJar(jar http.CookieJar) Option {
+	return func(d *Downloader) error {
+		d.jar = jar
+		return nil
+	}
+}
+
+// WithProxy 通过代理发送 scope 范围内的请求，rawURL 支持 http / https / socks5 / socks5h
+func WithProxy(rawURL string, scope utils.ProxyScope) Option {
+	return func(d *Downloader) error {
+		proxyURL, err := utils.ParseProxyURL(rawURL)
+		if err != nil {
+			return err
+		}
+		d.proxy = &utils.ProxyOptions{URL: proxyURL, Scope: scope}
+		return nil
+	}
+}
+
+// applyHTTPOverrides 在所有选项之后把 WithHeader、WithCookieJar 和 WithProxy 叠加到 HTTP 设置上，
+// 这样它们不会被之后的 WithHTTPOptions 覆盖
+func (d *Downloader) applyHTTPOverrides() {
+	options := &d.config.HTTP
+	if len(d.headers) > 0 {
+		common := options.Headers.Common.Clone() // 不修改调用方传入的请求头
+		if common == nil {
+			common = make(http.Header, len(d.headers))
+		}
+		for name, values := range d.headers {
+			for _, value := range values {
+				common.Add(name, value)
+			}
+		}
+		options.Headers.Common = common
+	}
+	if d.jar != nil {
+		options.Jar = d.jar
+	}
+	if d.proxy != nil {
+		options.Proxy = *d.proxy
+	}
+}
+
+// WithHandler 设置录制事件的接收者
+func WithHandler(handler Handler) Option {
+	return func(d *Downloader) error {
+		if handler == nil {
+			return fmt.Errorf("事件接收者不能为 nil")
+		}
+		d.handler = handler
+		return nil
+	}
+}
+
+// WithFailureThreshold 设置一路媒体列表连续失败多少轮后发送 EventRepeatedFailure，默认 3，0 表示不发送
+func WithFailureThreshold(n int) Option {
+	return func(d *Downloader) error {
+		d.config.FailureThreshold = n
+		return nil
+	}
+}
+
+// WithDiskQuota 设置保存目录最多占用的字节数，超过后发送 EventDiskQuota 并停止录制，0 表示不限制
+func WithDiskQuota(bytes int64) Option {
+	return func(d *Downloader) error {
+		d.config.DiskQuota = bytes
+		return nil
+	}
+}
+
+// Webhook 事件回调的设置，零值的字段使用默认值。设置了 Secret 时，X-HLS-Timestamp 头为发送时的 Unix 秒数，
+// 签名同时覆盖时间戳和请求体，接收方应拒绝时间相差过大的请求，防止截获的请求被重放
+type Webhook struct {
+	URL         string        // 接收事件的地址，每个事件以 JSON POST 一次
+	Secret      string        // 签名密钥，非空时 X-HLS-Signature 头为 "sha256=" + HMAC-SHA256(X-HLS-Timestamp + "." + 请求体) 的十六进制
+	Events      []EventType   // 需要发送的事件，默认为录制开始、流结束、停滞、连续失败和超过磁盘配额
+	Timeout     time.Duration // 单次请求的超时，默认 10 秒
+	MaxAttempts int           // 每个事件最多尝试的次数，默认 5
+	RetryDelay  time.Duration // 第一次重试前的退避上限，之后每次翻倍，默认 1 秒
+	QueueSize   int           // 等待发送的事件数上限，队列满时丢弃新事件，默认 1024
+}
+
+// WithWebhook 把事件 POST 到 HTTP 地址，可以多次使用。事件在后台发送，不阻塞录制；Start 返回前会等待队列发送完
+func WithWebhook(w Webhook) Option {
+	return func(d *Downloader) error {
+		options := downloader.DefaultWebhookOptions()
+		options.URL = w.URL
+		options.Secret = w.Secret
+		for _, event := range w.Events {
+			options.Events = append(options.Events, string(event))
+		}
+		if w.Timeout > 0 {
+			options.Timeout = w.Timeout
+		}
+		if w.MaxAttempts > 0 {
+			options.MaxAttempts = w.MaxAttempts
+		}
+		if w.RetryDelay > 0 {
+			options.RetryDelay = w.RetryDelay
+		}
+		if w.QueueSize > 0 {
+			options.QueueSize = w.QueueSize
+		}
+
+		// 这里只检查设置，回调本身在 Start 时创建
+		if err := options.Validate(); err != nil {
+			return err
+		}
+		d.webhooks = append(d.webhooks, options)
+		return nil
+	}
+}
@@ -0,0 +1,129 @@
+package hls
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MGter/hls_downloader/pkg/utils"
+)
+
+const testURL = "https://example.com/live/index.m3u8"
+
+func TestNewRejectsInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		opts []Option
+		want []string // 错误信息中应包含的内容
+	}{
+		{name: "empty url", url: "", want: []string{"播放列表地址"}},
+		{name: "concurrency", url: testURL, opts: []Option{WithConcurrency(0)}, want: []string{"并发下载数"}},
+		{name: "retry", url: testURL, opts: []Option{WithRetry(0, -time.Second, 0)}, want: []string{"最大尝试次数", "重试等待时间"}},
+		{name: "reload bounds", url: testURL, opts: []Option{WithReloadInterval(10*time.Second, time.Second)}, want: []string{"最小刷新间隔"}},
+		{name: "variant policy", url: testURL, opts: []Option{WithVariant(Variant{Policy: "best"})}, want: []string{"best"}},
+		{name: "resolution target", url: testURL, opts: []Option{WithVariant(Variant{Policy: VariantResolution})}, want: []string{"目标分辨率"}},
+		{name: "segment identity", url: testURL, opts: []Option{WithSegmentIdentity("uri")}, want: []string{"uri"}},
+		{name: "nil handler", url: testURL, opts: []Option{WithHandler(nil)}, want: []string{"事件接收者"}},
+		{name: "proxy scheme", url: testURL, opts: []Option{WithProxy("ftp://proxy:21", utils.ProxyAll)}, want: []string{"ftp"}},
+		{name: "webhook url", url: testURL, opts: []Option{WithWebhook(Webhook{URL: "example.com/hook"})}, want: []string{"example.com/hook"}},
+		{name: "webhook event", url: testURL, opts: []Option{WithWebhook(Webhook{URL: "https://example.com/hook", Events: []EventType{"segment_done"}})}, want: []string{"segment_done"}},
+		{
+			name: "all option errors reported",
+			url:  testURL,
+			opts: []Option{WithHandler(nil), WithProxy("ftp://proxy:21", utils.ProxyAll)},
+			want: []string{"事件接收者", "ftp"},
+		},
+	}
+	for _, tt := range tests {
+		d, err := New(tt.url, tt.opts...)
+		if err == nil {
+			t.Errorf("%s: New succeeded (%+v), want error", tt.name, d)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error %q does not mention %q", tt.name, err, want)
+			}
+		}
+	}
+}
+
+func TestNewAppliesOptions(t *testing.T) {
+	d, err := New(testURL,
+		WithOutputDir("records/live"),
+		WithConcurrency(3),
+		WithRetry(7, 2*time.Second, time.Minute),
+		WithVariant(Variant{Policy: VariantResolution, Width: 1280, Height: 720, Codecs: []string{"avc1"}}),
+		WithSegmentIdentity(IdentityFilename),
+		WithDiskQuota(1<<30),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := d.config
+	if c.OutputDir != "records/live" || c.MaxConcurrentDownloads != 3 || c.MaxRetryAttempts != 7 ||
+		c.RetryDelayBase != 2*time.Second || c.RetryMaxDelay != time.Minute || c.DiskQuota != 1<<30 {
+		t.Errorf("config %+v", c)
+	}
+	v := c.VariantSelection
+	if string(v.Policy) != "resolution" || v.TargetWidth != 1280 || v.TargetHeight != 720 || !slices.Equal(v.CodecPreference, []string{"avc1"}) {
+		t.Errorf("variant selection %+v", v)
+	}
+	if string(c.SegmentIdentity) != "filename" {
+		t.Errorf("segment identity %q", c.SegmentIdentity)
+	}
+}
+
+// WithHeader、WithCookieJar 和 WithProxy 放在 WithHTTPOptions 之前或之后结果相同
+func TestHTTPOptionsOrderIndependent(t *testing.T) {
+	jar, _ := cookiejar.New(nil)
+	base := utils.DefaultClientOptions()
+	base.ConnectTimeout = 3 * time.Second
+	base.Headers.Common = http.Header{"User-Agent": {"custom-agent"}}
+
+	headerOpts := []Option{
+		WithHeader("Referer", "https://example.com/"),
+		WithHeader("X-Token", "a"),
+		WithHeader("X-Token", "b"),
+		WithCookieJar(jar),
+		WithProxy("socks5://127.0.0.1:1080", utils.ProxySegments),
+	}
+	orders := map[string][]Option{
+		"http options first": append([]Option{WithHTTPOptions(base)}, headerOpts...),
+		"http options last":  append(slices.Clone(headerOpts), WithHTTPOptions(base)),
+	}
+	for name, opts := range orders {
+		d, err := New(testURL, opts...)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		h := d.config.HTTP
+		if h.ConnectTimeout != 3*time.Second {
+			t.Errorf("%s: ConnectTimeout %v, want 3s", name, h.ConnectTimeout)
+		}
+		if got := h.Headers.Common.Get("User-Agent"); got != "custom-agent" {
+			t.Errorf("%s: User-Agent %q", name, got)
+		}
+		if got := h.Headers.Common.Get("Referer"); got != "https://example.com/" {
+			t.Errorf("%s: Referer %q", name, got)
+		}
+		if got := h.Headers.Common.Values("X-Token"); !slices.Equal(got, []string{"a", "b"}) {
+			t.Errorf("%s: X-Token %q", name, got)
+		}
+		if h.Jar != jar {
+			t.Errorf("%s: cookie jar was dropped", name)
+		}
+		if h.Proxy.URL == nil || h.Proxy.URL.Host != "127.0.0.1:1080" || h.Proxy.Scope != utils.ProxySegments {
+			t.Errorf("%s: proxy %+v", name, h.Proxy)
+		}
+	}
+
+	// 调用方传入的请求头不会被修改
+	if base.Headers.Common.Get("Referer") != "" {
+		t.Error("WithHeader modified the caller's ClientOptions headers")
+	}
+}
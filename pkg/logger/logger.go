@@ -1,11 +1,58 @@
 package logger
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 )
 
 var (
+	Debug = log.New(io.Discard, "DEBUG: ", log.Ldate|log.Ltime|log.Lshortfile)
 	Info  = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
+	Warn  = log.New(os.Stderr, "WARN: ", log.Ldate|log.Ltime|log.Lshortfile)
 	Error = log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
-)
\ No newline at end of file
+)
+
+// Level 日志级别，低于该级别的日志不输出
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// ParseLevel 将字符串解析为日志级别
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("未知的日志级别: %s（可选 debug / info / warn / error）", name)
+}
+
+// SetLevel 设置日志级别。下载器内部的进度日志使用标准库 log，按 info 级别处理；
+// 警告和错误直接写入 Warn 和 Error，在 warn / error 级别下仍会输出
+func SetLevel(level Level) {
+	enable := func(l *log.Logger, min Level, w io.Writer) {
+		if level <= min {
+			l.SetOutput(w)
+		} else {
+			l.SetOutput(io.Discard)
+		}
+	}
+	enable(Debug, LevelDebug, os.Stdout)
+	enable(Info, LevelInfo, os.Stdout)
+	enable(Warn, LevelWarn, os.Stderr)
+	enable(Error, LevelError, os.Stderr)
+	enable(log.Default(), LevelInfo, os.Stderr)
+}
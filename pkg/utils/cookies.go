@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpOnlyPrefix curl/浏览器导出的 HttpOnly cookie 在域名前带有这个前缀
+const httpOnlyPrefix = "#HttpOnly_"
+
+// LoadCookieFile 读取 Netscape 格式（curl、wget、浏览器插件导出）的 cookie 文件，返回包含这些 cookie 的 jar。
+// 每行七列，以制表符分隔：域名、是否包含子域名、路径、是否仅 HTTPS、过期时间、名称、值
+func LoadCookieFile(filename string) (http.CookieJar, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("打开 cookie 文件失败: %w", err)
+	}
+	defer file.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+		}
+		// 跳过空行和注释
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("cookie 文件第 %d 行应有7列，实际为 %d 列", lineNo, len(fields))
+		}
+		domain, includeSubdomains, cookiePath, secure, expires, name, value :=
+			fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+
+		cookie := &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     cookiePath,
+			Secure:   strings.EqualFold(secure, "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		// 包含子域名的是域 cookie，否则只发给该主机
+		if strings.EqualFold(includeSubdomains, "TRUE") {
+			cookie.Domain = domain
+		}
+		// 过期时间为0表示会话 cookie
+		if seconds, err := strconv.ParseInt(expires, 10, 64); err != nil {
+			return nil, fmt.Errorf("cookie 文件第 %d 行的过期时间无效: %s", lineNo, expires)
+		} else if seconds > 0 {
+			cookie.Expires = time.Unix(seconds, 0)
+		}
+
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: strings.TrimPrefix(domain, "."), Path: cookiePath}, []*http.Cookie{cookie})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取 cookie 文件失败: %w", err)
+	}
+
+	return jar, nil
+}
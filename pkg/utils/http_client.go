@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientOptions HTTP客户端的超时和连接池设置，0 表示不限制
+type ClientOptions struct {
+	ConnectTimeout        time.Duration // 建立TCP连接的超时
+	TLSHandshakeTimeout   time.Duration // TLS握手的超时
+	ResponseHeaderTimeout time.Duration // 发出请求后等待响应头的超时
+	TransferTimeout       time.Duration // 单个请求从发出到读完响应体的总超时
+	MaxIdleConnsPerHost   int           // 每个主机保留的空闲连接数，一般与并发下载数相当，0 时使用 http 包的默认值
+	MinThroughput         int64         // 读取响应体的最低速度（字节/秒），低于该速度时中断请求
+	ThroughputWindow      time.Duration // 计算读取速度的时间窗口
+	Headers               RequestHeaders // 附加到请求上的自定义请求头
+	Jar                   http.CookieJar // cookie 存储，nil 时使用空的 jar；播放列表返回的 Set-Cookie 会带到后续请求
+	Proxy                 ProxyOptions   // 代理设置，未指定时沿用环境变量
+}
+
+// RequestKind 请求的类型，用于按类型附加请求头
+type RequestKind int
+
+const (
+	KindPlaylist RequestKind = iota // 主播放列表和媒体播放列表
+	KindKey                         // 解密密钥
+	KindSegment                     // 媒体片段和初始化片段
+)
+
+// RequestHeaders 附加到请求上的自定义请求头，同名时按类型设置的优先
+type RequestHeaders struct {
+	Common   http.Header // 所有请求，例如 User-Agent、Referer
+	Playlist http.Header // 只用于播放列表请求
+	Key      http.Header // 只用于密钥请求
+	Segment  http.Header // 只用于片段请求
+}
+
+// apply 把通用请求头和该类型的请求头设置到请求上；Host 头改写请求的主机名
+func (h RequestHeaders) apply(kind RequestKind, req *http.Request) {
+	specific := map[RequestKind]http.Header{
+		KindPlaylist: h.Playlist,
+		KindKey:      h.Key,
+		KindSegment:  h.Segment,
+	}[kind]
+
+	for _, headers := range []http.Header{h.Common, specific} {
+		for name, values := range headers {
+			if http.CanonicalHeaderKey(name) == "Host" && len(values) > 0 {
+				req.Host = values[len(values)-1]
+				continue
+			}
+			req.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+}
+
+// DefaultClientOptions 返回默认的客户端设置
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		ConnectTimeout:        10 * time.Second,  // 10秒内建立连接
+		TLSHandshakeTimeout:   10 * time.Second,  // 10秒内完成TLS握手
+		ResponseHeaderTimeout: 15 * time.Second,  // 15秒内收到响应头
+		TransferTimeout:       2 * time.Minute,   // 单个请求最多2分钟
+		MaxIdleConnsPerHost:   0,                 // 由使用方按并发数设置，下载器按并发下载数 + 2
+		MinThroughput:         4 * 1024,          // 低于 4KiB/s 视为卡住
+		ThroughputWindow:      10 * time.Second,  // 每10秒检查一次读取速度
+	}
+}
+
+// Client 播放列表、密钥和片段下载共用的HTTP客户端，带超时、连接池和慢速传输检测
+type Client struct {
+	http    *http.Client  // 底层客户端
+	options ClientOptions // 客户端设置
+}
+
+// NewClient 按设置创建HTTP客户端
+func NewClient(options ClientOptions) *Client {
+	jar := options.Jar
+	if jar == nil {
+		// cookiejar.New 只在传入的选项无效时出错，nil 选项不会失败
+		jar, _ = cookiejar.New(nil)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   options.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 options.Proxy.proxyFunc(),
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   options.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: options.ResponseHeaderTimeout,
+		MaxIdleConns:          max(options.MaxIdleConnsPerHost*4, 100),
+		MaxIdleConnsPerHost:   max(options.MaxIdleConnsPerHost, http.DefaultMaxIdleConnsPerHost),
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+	return &Client{
+		http:    &http.Client{Transport: transport, Jar: jar},
+		options: options,
+	}
+}
+
+// defaultClient 包级函数使用的默认客户端
+var defaultClient = NewClient(DefaultClientOptions())
+
+// TransferError 传输超过总超时或读取速度过慢，按超时处理，可以重试
+type TransferError struct {
+	Reason string
+}
+
+func (e *TransferError) Error() string { return e.Reason }
+
+// Timeout 实现 net.Error，使其归类为超时
+func (e *TransferError) Timeout() bool { return true }
+
+// Temporary 实现 net.Error
+func (e *TransferError) Temporary() bool { return true }
+
+// Do 按请求类型附加请求头后发送请求；返回的响应体在读取期间受总超时和最低速度限制，调用方必须关闭响应体
+func (c *Client) Do(kind RequestKind, req *http.Request) (*http.Response, error) {
+	c.options.Headers.apply(kind, req)
+
+	ctx, cancel := context.WithCancelCause(withRequestKind(req.Context(), kind))
+	stopTimer := func() bool { return false }
+	if c.options.TransferTimeout > 0 {
+		timeout := c.options.TransferTimeout
+		stopTimer = time.AfterFunc(timeout, func() {
+			cancel(&TransferError{Reason: fmt.Sprintf("传输超过 %v 仍未完成", timeout)})
+		}).Stop
+	}
+
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		stopTimer()
+		err = transferCause(ctx, err)
+		cancel(nil)
+		return nil, err
+	}
+
+	body := &watchedBody{
+		body:   resp.Body,
+		ctx:    ctx,
+		cancel: cancel,
+		stop:   stopTimer,
+		done:   make(chan struct{}),
+	}
+	if c.options.MinThroughput > 0 && c.options.ThroughputWindow > 0 {
+		go body.watch(c.options.MinThroughput, c.options.ThroughputWindow)
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// transferCause 请求因总超时或速度过慢被中断时，返回具体原因而不是 context canceled
+func transferCause(ctx context.Context, err error) error {
+	var transferErr *TransferError
+	if errors.As(err, &transferErr) {
+		return err
+	}
+	if errors.As(context.Cause(ctx), &transferErr) {
+		return fmt.Errorf("%w: %v", transferErr, err)
+	}
+	return err
+}
+
+// watchedBody 统计读取速度的响应体，关闭时释放计时器和上下文
+type watchedBody struct {
+	body   io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	stop   func() bool
+	read   atomic.Int64  // 当前窗口内读取的字节数
+	done   chan struct{} // 关闭后通知检测协程退出
+	once   sync.Once
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.read.Add(int64(n))
+	if err != nil && err != io.EOF {
+		err = transferCause(b.ctx, err)
+	}
+	return n, err
+}
+
+func (b *watchedBody) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+		b.stop()
+		b.cancel(nil)
+	})
+	return b.body.Close()
+}
+
+// watch 每个窗口检查一次读取量，低于最低速度时中断请求
+func (b *watchedBody) watch(minThroughput int64, window time.Duration) {
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+
+	minBytes := int64(float64(minThroughput) * window.Seconds())
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			if n := b.read.Swap(0); n < minBytes {
+				b.cancel(&TransferError{Reason: fmt.Sprintf("传输过慢: %v 内只收到 %d 字节，低于 %d 字节/秒", window, n, minThroughput)})
+				return
+			}
+		}
+	}
+}
+
+// GetBytes 发送HTTP GET请求并返回原始响应体，失败时按 policy 重试
+func (c *Client) GetBytes(ctx context.Context, kind RequestKind, url string, policy RetryPolicy) ([]byte, error) {
+	var body []byte
+	err := policy.Do(ctx, func(int) error {
+		var err error
+		body, err = c.getOnce(ctx, kind, url)
+		return err
+	})
+	return body, err
+}
+
+// Get 发送HTTP GET请求并以字符串返回响应体，失败时按 policy 重试
+func (c *Client) Get(ctx context.Context, kind RequestKind, url string, policy RetryPolicy) (string, error) {
+	body, err := c.GetBytes(ctx, kind, url, policy)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// getOnce 发送一次HTTP GET请求
+func (c *Client) getOnce(ctx context.Context, kind RequestKind, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &PermanentError{Err: fmt.Errorf("创建HTTP请求失败: %w", err)}
+	}
+
+	resp, err := c.Do(kind, req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewHTTPStatusError(resp)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应体失败: %w", err)
+	}
+
+	return body, nil
+}
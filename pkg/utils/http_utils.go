@@ -1,27 +1,15 @@
 package utils
 
 import (
-	"fmt"
-	"io"
-	"net/http"
+	"context"
 )
 
-// HTTPGet 发送HTTP GET请求并返回响应体
-func HTTPGet(url string) (string, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("HTTP请求失败: %w", err)
-	}
-	defer resp.Body.Close()
+// HTTPGet 使用默认客户端发送HTTP GET请求并返回响应体，失败时按 policy 重试
+func HTTPGet(ctx context.Context, url string, policy RetryPolicy) (string, error) {
+	return defaultClient.Get(ctx, KindPlaylist, url, policy)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP状态码: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("读取响应体失败: %w", err)
-	}
-
-	return string(body), nil
-}
\ No newline at end of file
+// HTTPGetBytes 使用默认客户端发送HTTP GET请求并返回原始响应体，用于密钥等二进制内容；失败时按 policy 重试
+func HTTPGetBytes(ctx context.Context, url string, policy RetryPolicy) ([]byte, error) {
+	return defaultClient.GetBytes(ctx, KindKey, url, policy)
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy 请求失败后的重试策略：指数退避 + 全抖动，遇到 429/503 时遵循 Retry-After
+type RetryPolicy struct {
+	MaxAttempts int           // 最多请求次数（包括第一次），小于1时按1处理
+	BaseDelay   time.Duration // 第一次重试前的退避上限，之后每次翻倍
+	MaxDelay    time.Duration // 每次等待的最长时间，对 Retry-After 同样生效，0 表示不限制
+}
+
+// DefaultRetryPolicy 返回默认的重试策略
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,                // 最多请求3次
+		BaseDelay:   time.Second,      // 第一次重试前最多等待1秒
+		MaxDelay:    30 * time.Second, // 每次最多等待30秒
+	}
+}
+
+// Do 按策略执行 fn，直到成功、遇到不可重试的错误、次数用尽或 ctx 被取消；
+// 返回最后一次的错误，attempt 从1开始计数
+func (p RetryPolicy) Do(ctx context.Context, fn func(attempt int) error) error {
+	attempts := max(p.MaxAttempts, 1)
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(attempt); err == nil {
+			return nil
+		}
+
+		// 请求被取消或错误不会因重试而改变，直接返回
+		if ctx.Err() != nil || !Retryable(err) || attempt == attempts {
+			return err
+		}
+
+		timer := time.NewTimer(p.Delay(attempt, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+	return err
+}
+
+// Delay 返回第 attempt 次失败后的等待时间。
+// 服务器通过 Retry-After 指定了时间时按其等待（不超过 MaxDelay），否则在 [0, min(MaxDelay, BaseDelay*2^(attempt-1))) 中随机取值
+func (p RetryPolicy) Delay(attempt int, err error) time.Duration {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		// 直播片段很快会滑出列表，服务器要求等待一小时也只等 MaxDelay
+		if p.MaxDelay > 0 {
+			return min(statusErr.RetryAfter, p.MaxDelay)
+		}
+		return statusErr.RetryAfter
+	}
+
+	ceiling := p.BaseDelay
+	for i := 1; i < attempt && ceiling > 0; i++ {
+		ceiling *= 2
+		if p.MaxDelay > 0 && ceiling >= p.MaxDelay {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+// HTTPStatusError 服务器返回了非预期的HTTP状态码
+type HTTPStatusError struct {
+	StatusCode int           // HTTP状态码
+	RetryAfter time.Duration // 429/503 响应中 Retry-After 要求的等待时间，0 表示未指定
+}
+
+// NewHTTPStatusError 根据响应生成状态码错误，429/503 时解析 Retry-After
+func NewHTTPStatusError(resp *http.Response) *HTTPStatusError {
+	err := &HTTPStatusError{StatusCode: resp.StatusCode}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		err.RetryAfter = ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return err
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("HTTP状态码: %d（Retry-After %v）", e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("HTTP状态码: %d", e.StatusCode)
+}
+
+// ParseRetryAfter 解析 Retry-After 头，支持秒数和HTTP日期两种格式，无法解析时返回0
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+// retryableStatus 重试可能成功的HTTP状态码，其余 4xx/5xx 视为永久错误
+var retryableStatus = map[int]bool{
+	http.StatusRequestTimeout:      true, // 408
+	http.StatusTooEarly:            true, // 425
+	http.StatusTooManyRequests:     true, // 429
+	http.StatusInternalServerError: true, // 500
+	http.StatusBadGateway:          true, // 502
+	http.StatusServiceUnavailable:  true, // 503
+	http.StatusGatewayTimeout:      true, // 504
+}
+
+// PermanentError 标记不应重试的错误
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Retryable 判断错误是否值得重试：只有超时、连接错误、响应体中断和部分状态码可以重试；
+// 404/403 等客户端错误、域名不存在、证书错误、本地文件错误、解密失败和取消不会因重试而改变，
+// 无法识别的错误也按永久错误处理
+func Retryable(err error) bool {
+	var permanent *PermanentError
+	var statusErr *HTTPStatusError
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var pathErr *fs.PathError
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &permanent), errors.Is(err, context.Canceled):
+		return false
+	case errors.As(err, &statusErr):
+		return retryableStatus[statusErr.StatusCode]
+	case errors.As(err, &dnsErr):
+		return !dnsErr.IsNotFound
+	case errors.As(err, &certErr):
+		return false
+	case errors.As(err, &pathErr):
+		return false // 本地文件错误，syscall.Errno 也实现了 net.Error，需要先排除
+	case errors.As(err, &netErr):
+		return true // 连接、读取超时和传输过慢，以及 http.Client 返回的请求错误
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF), errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE):
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+	"crypto/aes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelayClampsRetryAfter(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+	err := fmt.Errorf("下载失败: %w", &HTTPStatusError{StatusCode: 503, RetryAfter: time.Hour})
+
+	if got := policy.Delay(1, err); got != 30*time.Second {
+		t.Errorf("Retry-After 1h with MaxDelay 30s: delay %v, want 30s", got)
+	}
+
+	short := &HTTPStatusError{StatusCode: 429, RetryAfter: 2 * time.Second}
+	if got := policy.Delay(1, short); got != 2*time.Second {
+		t.Errorf("Retry-After 2s: delay %v, want 2s", got)
+	}
+
+	unlimited := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second}
+	if got := unlimited.Delay(1, err); got != time.Hour {
+		t.Errorf("Retry-After without MaxDelay: delay %v, want 1h", got)
+	}
+}
+
+func TestRetryPolicyDelayBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, ceiling := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		for i := 0; i < 100; i++ {
+			if got := policy.Delay(attempt, errors.New("x")); got < 0 || got >= ceiling {
+				t.Fatalf("attempt %d: delay %v outside [0, %v)", attempt, got, ceiling)
+			}
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	_, keyErr := aes.NewCipher(make([]byte, 5))
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"503", &HTTPStatusError{StatusCode: 503}, true},
+		{"429", &HTTPStatusError{StatusCode: 429}, true},
+		{"404", &HTTPStatusError{StatusCode: 404}, false},
+		{"403 wrapped", fmt.Errorf("下载失败: %w", &HTTPStatusError{StatusCode: 403}), false},
+		{"network timeout", timeout, true},
+		{"slow transfer", &TransferError{Reason: "传输过慢"}, true},
+		{"connection reset", fmt.Errorf("读取失败: %w", syscall.ECONNRESET), true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{"dns not found", &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}, false},
+		{"dns temporary", &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}, true},
+		{"truncated body", fmt.Errorf("读取响应体失败: %w", io.ErrUnexpectedEOF), true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"canceled", fmt.Errorf("下载已取消: %w", context.Canceled), false},
+		{"permanent", &PermanentError{Err: timeout}, false},
+		{"local file", &os.PathError{Op: "open", Path: "/nonexistent/x.ts", Err: os.ErrNotExist}, false},
+		{"disk full", &os.PathError{Op: "write", Path: "x.ts", Err: syscall.ENOSPC}, false},
+		{"bad key", fmt.Errorf("解密失败: %w", keyErr), false},
+		{"padding", errors.New("PKCS#7 填充无效"), false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("%s: Retryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+// Do 遇到永久错误时不再重试，可重试的错误按次数用尽
+func TestRetryPolicyDo(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4}
+
+	calls := 0
+	err := policy.Do(context.Background(), func(int) error {
+		calls++
+		return errors.New("PKCS#7 填充无效")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("unknown error: %d calls, want 1", calls)
+	}
+
+	calls = 0
+	policy.Do(context.Background(), func(int) error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	if calls != 4 {
+		t.Errorf("retryable error: %d calls, want 4", calls)
+	}
+}